@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var errAllocatorStopped = errors.New("seat allocator is not running")
+
+// seatAllocator serializes seat allocation in the application instead of the database. A single goroutine owns
+// the list of free seats of an event and hands each one out exactly once, so customers never compete for the
+// same row and can write their assignment in parallel. Seats booked behind its back, e.g. by group bookings, fail
+// to be claimed and are dropped. Seats freed by cancellations must be given back with releaseQueued.
+type seatAllocator struct {
+	requests chan chan int
+	releases chan int
+	done     chan struct{}
+}
+
+// newSeatAllocator starts an allocator that owns the given free seats.
+func newSeatAllocator(free []int) *seatAllocator {
+	a := &seatAllocator{
+		requests: make(chan chan int),
+		releases: make(chan int),
+		done:     make(chan struct{}),
+	}
+	go a.run(free)
+	return a
+}
+
+func (a *seatAllocator) run(free []int) {
+	for {
+		select {
+		case reply := <-a.requests:
+			if len(free) == 0 {
+				reply <- 0
+				continue
+			}
+			reply <- free[0]
+			free = free[1:]
+		case seatID := <-a.releases:
+			free = append(free, seatID)
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// allocate returns a free seat that no other caller will get, or 0 if every seat has been handed out.
+func (a *seatAllocator) allocate(ctx context.Context) (int, error) {
+	reply := make(chan int, 1)
+	select {
+	case a.requests <- reply:
+	case <-a.done:
+		return 0, errAllocatorStopped
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	return <-reply, nil
+}
+
+// release gives a seat that could not be booked back to the allocator.
+func (a *seatAllocator) release(seatID int) {
+	select {
+	case a.releases <- seatID:
+	case <-a.done:
+	}
+}
+
+func (a *seatAllocator) stop() {
+	close(a.done)
+}
+
+type allocatorKey struct {
+	store   SeatStore
+	eventID int
+}
+
+var (
+	allocatorsMu sync.Mutex
+	allocators   = map[allocatorKey]*seatAllocator{}
+)
+
+// startAllocator starts the allocator of an event, loading its free seats from the store. The returned function
+// stops it again.
+func startAllocator(ctx context.Context, store SeatStore, eventID int) (func(), error) {
+	free, err := store.FreeSeats(ctx, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading free seats: %v", err)
+	}
+
+	a := newSeatAllocator(free)
+	key := allocatorKey{store: store, eventID: eventID}
+
+	allocatorsMu.Lock()
+	if previous, ok := allocators[key]; ok {
+		previous.stop()
+	}
+	allocators[key] = a
+	allocatorsMu.Unlock()
+
+	return func() {
+		allocatorsMu.Lock()
+		defer allocatorsMu.Unlock()
+
+		if allocators[key] == a {
+			delete(allocators, key)
+		}
+		a.stop()
+	}, nil
+}
+
+// bookSeatQueued asks the event's allocator goroutine for a seat over a channel and then claims it. The allocator
+// must have been started with startAllocator. If the write fails, the seat goes back to the allocator. If the seat
+// was taken by someone bypassing the allocator, it asks for the next one.
+func bookSeatQueued(store SeatStore, eventID, customerID int) (int, error) {
+	ctx := context.Background()
+
+	allocatorsMu.Lock()
+	a, ok := allocators[allocatorKey{store: store, eventID: eventID}]
+	allocatorsMu.Unlock()
+	if !ok {
+		return 0, errAllocatorStopped
+	}
+
+	for {
+		seatID, err := a.allocate(ctx)
+		if err != nil {
+			return 0, err
+		}
+		if seatID == 0 {
+			return 0, ErrSoldOut
+		}
+
+		claimed, err := claimSeat(ctx, store, seatID, customerID)
+		if err != nil {
+			a.release(seatID)
+			return 0, err
+		}
+		if claimed {
+			return seatID, nil
+		}
+	}
+}
+
+// releaseQueued gives a seat freed in the store back to the event's allocator, if it is running. Handing out a
+// seat the allocator already had is harmless: the second claim fails and the seat is dropped.
+func releaseQueued(store SeatStore, eventID, seatID int) {
+	allocatorsMu.Lock()
+	a, ok := allocators[allocatorKey{store: store, eventID: eventID}]
+	allocatorsMu.Unlock()
+	if ok {
+		a.release(seatID)
+	}
+}
+
+// claimSeat assigns a seat to the customer in its own transaction if it is still free.
+func claimSeat(ctx context.Context, store SeatStore, seatID, customerID int) (bool, error) {
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	claimed, err := tx.ClaimSeat(ctx, seatID, customerID)
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BookingAttempt is a single call to a strategy's Book function as seen by the customer.
+type BookingAttempt struct {
+	EventID    int
+	CustomerID int
+	// SeatID is the seat the strategy reported as booked, or 0 if the attempt failed.
+	SeatID int
+	// Group is set for the seats of a group booking, which are recorded as one attempt per seat.
+	Group      bool
+	Err        error
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// AuditLog collects booking attempts in memory and writes them to the `booking_attempts` table on Flush, so that
+// auditing a run doesn't add a round trip to every measured booking. A nil *AuditLog discards all attempts.
+type AuditLog struct {
+	mu       sync.Mutex
+	attempts []BookingAttempt
+}
+
+func NewAuditLog() *AuditLog {
+	return &AuditLog{}
+}
+
+// Record adds an attempt to the log.
+func (a *AuditLog) Record(attempt BookingAttempt) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.attempts = append(a.attempts, attempt)
+}
+
+// Attempts returns the attempts recorded since the last Flush.
+func (a *AuditLog) Attempts() []BookingAttempt {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return slices.Clone(a.attempts)
+}
+
+// Flush inserts every recorded attempt into the `booking_attempts` table and clears the log.
+func (a *AuditLog) Flush(db *sql.DB) error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	attempts := a.attempts
+	a.attempts = nil
+	a.mu.Unlock()
+
+	const batchSize = 1000
+	for len(attempts) > 0 {
+		batch := attempts[:min(batchSize, len(attempts))]
+		attempts = attempts[len(batch):]
+
+		values := make([]string, len(batch))
+		args := make([]any, 0, len(batch)*7)
+		for i, attempt := range batch {
+			n := len(args)
+			values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7)
+
+			var seatID sql.NullInt64
+			var errMsg sql.NullString
+			if attempt.Err != nil {
+				errMsg = sql.NullString{String: attempt.Err.Error(), Valid: true}
+			} else {
+				seatID = sql.NullInt64{Int64: int64(attempt.SeatID), Valid: true}
+			}
+			args = append(args,
+				attempt.EventID, attempt.CustomerID, seatID, attempt.Group, errMsg, attempt.StartedAt, attempt.FinishedAt,
+			)
+		}
+
+		_, err := db.Exec(fmt.Sprintf(`
+			INSERT INTO booking_attempts (event_id, customer_id, seat_id, group_booking, error, started_at, finished_at)
+			VALUES %s
+		`, strings.Join(values, ",")), args...)
+		if err != nil {
+			return fmt.Errorf("error inserting booking attempts: %v", err)
+		}
+	}
+
+	return nil
+}
+
+const (
+	doubleBooking = "customer has more than one seat"
+	lostUpdate    = "successful attempt is not reflected in the final table"
+	seatReused    = "seat was assigned to more than one customer"
+)
+
+// Violation is a broken booking invariant found by checkInvariants.
+type Violation struct {
+	Kind   string
+	Detail string
+}
+
+// checkInvariants checks the final seat assignments of an event against the booking attempts made for it. It
+// reports customers holding more than one seat outside of their group bookings, successful attempts whose seat
+// ended up with someone else or no one (lost updates), and seats that more than one successful attempt claimed.
+func checkInvariants(attempts []BookingAttempt, assignments map[int]int) []Violation {
+	var violations []Violation
+
+	groupSeats := map[int]int{}
+	for _, a := range attempts {
+		if a.Group && a.Err == nil {
+			groupSeats[a.SeatID] = a.CustomerID
+		}
+	}
+
+	seatsByCustomer := map[int][]int{}
+	for seatID, customerID := range assignments {
+		if owner, ok := groupSeats[seatID]; ok && owner == customerID {
+			continue
+		}
+		seatsByCustomer[customerID] = append(seatsByCustomer[customerID], seatID)
+	}
+	for _, customerID := range slices.Sorted(maps.Keys(seatsByCustomer)) {
+		seats := seatsByCustomer[customerID]
+		if len(seats) > 1 {
+			slices.Sort(seats)
+			violations = append(violations, Violation{
+				Kind:   doubleBooking,
+				Detail: fmt.Sprintf("customer %d has seats %s", customerID, joinInts(seats)),
+			})
+		}
+	}
+
+	successful := slices.DeleteFunc(slices.Clone(attempts), func(a BookingAttempt) bool { return a.Err != nil })
+	slices.SortStableFunc(successful, func(a, b BookingAttempt) int {
+		return cmp.Or(cmp.Compare(a.SeatID, b.SeatID), a.FinishedAt.Compare(b.FinishedAt))
+	})
+
+	customersBySeat := map[int][]int{}
+	for _, a := range successful {
+		if owner, ok := assignments[a.SeatID]; !ok || owner != a.CustomerID {
+			detail := "no one"
+			if ok {
+				detail = fmt.Sprintf("customer %d", owner)
+			}
+			violations = append(violations, Violation{
+				Kind:   lostUpdate,
+				Detail: fmt.Sprintf("customer %d booked seat %d, which belongs to %s", a.CustomerID, a.SeatID, detail),
+			})
+		}
+		if !slices.Contains(customersBySeat[a.SeatID], a.CustomerID) {
+			customersBySeat[a.SeatID] = append(customersBySeat[a.SeatID], a.CustomerID)
+		}
+	}
+	for _, seatID := range slices.Sorted(maps.Keys(customersBySeat)) {
+		customers := customersBySeat[seatID]
+		if len(customers) > 1 {
+			violations = append(violations, Violation{
+				Kind:   seatReused,
+				Detail: fmt.Sprintf("seat %d was booked by customers %s", seatID, joinInts(customers)),
+			})
+		}
+	}
+
+	return violations
+}
+
+// verifyBookings checks the invariants of an event using the `booking_attempts` audit table and the final
+// `bookings` table. Seats cancelled through the API show up as lost updates, so it is meant to be run right after
+// a workload.
+func verifyBookings(db *sql.DB, eventID int) ([]Violation, error) {
+	rows, err := db.Query(`
+		SELECT customer_id, seat_id, group_booking, started_at, finished_at FROM booking_attempts
+		WHERE event_id = $1 AND seat_id IS NOT NULL
+	`, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying booking attempts: %v", err)
+	}
+	defer rows.Close()
+
+	var attempts []BookingAttempt
+	for rows.Next() {
+		attempt := BookingAttempt{EventID: eventID}
+		err = rows.Scan(&attempt.CustomerID, &attempt.SeatID, &attempt.Group, &attempt.StartedAt, &attempt.FinishedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning booking attempt: %v", err)
+		}
+		attempts = append(attempts, attempt)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying booking attempts: %v", err)
+	}
+
+	assignments, err := NewPostgresSeatStore(db).Assignments(context.Background(), eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	return checkInvariants(attempts, assignments), nil
+}
+
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+// printViolations writes a summary line per kind of violation followed by every violation found.
+func printViolations(w io.Writer, violations []Violation) {
+	if len(violations) == 0 {
+		fmt.Fprintln(w, "no violations found")
+		return
+	}
+
+	counts := map[string]int{}
+	for _, v := range violations {
+		counts[v.Kind]++
+	}
+	for _, kind := range []string{doubleBooking, lostUpdate, seatReused} {
+		if counts[kind] > 0 {
+			fmt.Fprintf(w, "%d x %s\n", counts[kind], kind)
+		}
+	}
+	for _, v := range violations {
+		fmt.Fprintf(w, "  %s: %s\n", v.Kind, v.Detail)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	// ErrNotEnoughSeats is returned when an event has no run of adjacent free seats long enough for a group.
+	ErrNotEnoughSeats = errors.New("not enough adjacent free seats")
+	// ErrSeatsTaken is returned when the seats picked for a hold were taken before they could be held.
+	ErrSeatsTaken = errors.New("seats were taken concurrently")
+	// ErrHoldNotFound is returned when a hold does not exist, was already confirmed, released or reaped.
+	ErrHoldNotFound = errors.New("hold not found")
+	// ErrHoldExpired is returned when a hold is confirmed after its TTL has passed.
+	ErrHoldExpired = errors.New("hold expired")
+)
+
+// SectionSpec describes a section of seats laid out in rows.
+type SectionSpec struct {
+	Name        string
+	Seats       int
+	SeatsPerRow int
+}
+
+// Seat identifies a single seat of an event.
+type Seat struct {
+	ID        int
+	SectionID int
+	Row       int
+	Number    int
+}
+
+// Hold reserves a group of adjacent seats for a customer until ExpiresAt.
+type Hold struct {
+	ID         int
+	EventID    int
+	CustomerID int
+	ExpiresAt  time.Time
+	Seats      []Seat
+}
+
+// createEvent inserts an event together with its sections and seats and returns the event ID.
+func createEvent(db *sql.DB, name string, sections []SectionSpec) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var eventID int
+	err = tx.QueryRow("INSERT INTO events (name) VALUES ($1) RETURNING event_id", name).Scan(&eventID)
+	if err != nil {
+		return 0, fmt.Errorf("error inserting event: %v", err)
+	}
+
+	for _, section := range sections {
+		var sectionID int
+		err = tx.QueryRow(
+			"INSERT INTO sections (event_id, name) VALUES ($1, $2) RETURNING section_id", eventID, section.Name,
+		).Scan(&sectionID)
+		if err != nil {
+			return 0, fmt.Errorf("error inserting section: %v", err)
+		}
+
+		seatsPerRow := max(section.SeatsPerRow, 1)
+		_, err = tx.Exec(`
+			INSERT INTO bookings (event_id, section_id, row_num, seat_num)
+			SELECT $1, $2, (n - 1) / $4 + 1, (n - 1) % $4 + 1 FROM generate_series(1, $3) AS n
+		`, eventID, sectionID, section.Seats, seatsPerRow)
+		if err != nil {
+			return 0, fmt.Errorf("error inserting seats: %v", err)
+		}
+	}
+
+	return eventID, tx.Commit()
+}
+
+// holdSeats reserves numSeats adjacent seats in the same row of an event for the given customer. The hold is
+// all-or-nothing: either every seat is held or none is. Seats that are held can't be booked by anyone else until
+// the hold is confirmed, released or reaped after ttl.
+func holdSeats(db *sql.DB, eventID, customerID, numSeats int, ttl time.Duration) (Hold, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Hold{}, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	hold, err := holdSeatsTx(tx, eventID, customerID, numSeats, ttl)
+	if err != nil {
+		return Hold{}, err
+	}
+
+	return hold, tx.Commit()
+}
+
+func holdSeatsTx(tx *sql.Tx, eventID, customerID, numSeats int, ttl time.Duration) (Hold, error) {
+	if numSeats <= 0 {
+		return Hold{}, fmt.Errorf("invalid number of seats: %d", numSeats)
+	}
+
+	// Free seats are grouped into islands of consecutive seat numbers. seat_num - ROW_NUMBER() is constant
+	// within an island, so the first island with at least numSeats seats is the first fitting run.
+	var sectionID, rowNum, firstSeat int
+	err := tx.QueryRow(`
+		SELECT section_id, row_num, MIN(seat_num) FROM (
+			SELECT section_id, row_num, seat_num,
+				seat_num - ROW_NUMBER() OVER (PARTITION BY section_id, row_num ORDER BY seat_num) AS island
+			FROM bookings WHERE event_id = $1 AND customer_id IS NULL AND hold_id IS NULL
+		) free
+		GROUP BY section_id, row_num, island HAVING COUNT(*) >= $2
+		ORDER BY section_id, row_num, MIN(seat_num) LIMIT 1
+	`, eventID, numSeats).Scan(&sectionID, &rowNum, &firstSeat)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Hold{}, ErrNotEnoughSeats
+		}
+		return Hold{}, fmt.Errorf("error finding adjacent seats: %v", err)
+	}
+
+	hold := Hold{EventID: eventID, CustomerID: customerID}
+	err = tx.QueryRow(`
+		INSERT INTO holds (event_id, customer_id, expires_at) VALUES ($1, $2, NOW() + $3 * INTERVAL '1 millisecond')
+		RETURNING hold_id, expires_at
+	`, eventID, customerID, ttl.Milliseconds()).Scan(&hold.ID, &hold.ExpiresAt)
+	if err != nil {
+		return Hold{}, fmt.Errorf("error inserting hold: %v", err)
+	}
+
+	// The seats were found without locks, so the update re-checks that they are still free. If another customer
+	// got any of them first, fewer rows are updated and the whole hold is rolled back.
+	rows, err := tx.Query(`
+		UPDATE bookings SET hold_id = $1, version = version + 1
+		WHERE event_id = $2 AND section_id = $3 AND row_num = $4 AND seat_num >= $5 AND seat_num < $5 + $6
+			AND customer_id IS NULL AND hold_id IS NULL
+		RETURNING seat_id, section_id, row_num, seat_num
+	`, hold.ID, eventID, sectionID, rowNum, firstSeat, numSeats)
+	if err != nil {
+		return Hold{}, fmt.Errorf("error holding seats: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var seat Seat
+		err = rows.Scan(&seat.ID, &seat.SectionID, &seat.Row, &seat.Number)
+		if err != nil {
+			return Hold{}, fmt.Errorf("error scanning seat: %v", err)
+		}
+		hold.Seats = append(hold.Seats, seat)
+	}
+	if err = rows.Err(); err != nil {
+		return Hold{}, fmt.Errorf("error holding seats: %w", err)
+	}
+	if len(hold.Seats) != numSeats {
+		return Hold{}, ErrSeatsTaken
+	}
+
+	return hold, nil
+}
+
+// confirmHold books every seat of an unexpired hold for the customer that placed it and removes the hold.
+func confirmHold(db *sql.DB, holdID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	err = confirmHoldTx(tx, holdID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func confirmHoldTx(tx *sql.Tx, holdID int) error {
+	// Locking the hold keeps the reaper and a concurrent release from removing it while it is being confirmed.
+	var customerID int
+	var expired bool
+	err := tx.QueryRow(
+		"SELECT customer_id, expires_at <= NOW() FROM holds WHERE hold_id = $1 FOR UPDATE", holdID,
+	).Scan(&customerID, &expired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrHoldNotFound
+		}
+		return fmt.Errorf("error scanning hold: %v", err)
+	}
+	if expired {
+		return ErrHoldExpired
+	}
+
+	_, err = tx.Exec(`
+		UPDATE bookings SET customer_id = $1, hold_id = NULL, version = version + 1 WHERE hold_id = $2
+	`, customerID, holdID)
+	if err != nil {
+		return fmt.Errorf("error confirming seats: %w", err)
+	}
+
+	_, err = tx.Exec("DELETE FROM holds WHERE hold_id = $1", holdID)
+	if err != nil {
+		return fmt.Errorf("error deleting hold: %v", err)
+	}
+
+	return nil
+}
+
+// releaseHold gives up a hold before it expires. Its seats become free again.
+func releaseHold(db *sql.DB, holdID int) error {
+	result, err := db.Exec("DELETE FROM holds WHERE hold_id = $1", holdID)
+	if err != nil {
+		return fmt.Errorf("error deleting hold: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error reading affected rows: %v", err)
+	}
+	if affected == 0 {
+		return ErrHoldNotFound
+	}
+
+	return nil
+}
+
+// bookGroup books numSeats adjacent seats for the given customer in a single transaction. Either every seat is
+// booked or none is.
+func bookGroup(db *sql.DB, eventID, customerID, numSeats int) ([]Seat, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	hold, err := holdSeatsTx(tx, eventID, customerID, numSeats, time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	err = confirmHoldTx(tx, hold.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return hold.Seats, tx.Commit()
+}
+
+// reapExpiredHolds deletes every expired hold and returns how many were removed. Deleting a hold frees its
+// seats through the ON DELETE SET NULL foreign key on bookings.hold_id.
+func reapExpiredHolds(db *sql.DB) (int, error) {
+	result, err := db.Exec("DELETE FROM holds WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, fmt.Errorf("error deleting expired holds: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error reading affected rows: %v", err)
+	}
+	return int(affected), nil
+}
+
+// runHoldReaper reaps expired holds every interval until ctx is cancelled.
+func runHoldReaper(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reaped, err := reapExpiredHolds(db)
+			if err != nil {
+				log.Printf("error reaping holds: %v", err)
+				continue
+			}
+			if reaped > 0 {
+				log.Printf("reaped %d expired holds", reaped)
+			}
+		}
+	}
+}
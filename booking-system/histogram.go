@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// Every power of two range of microseconds is split into 64 linear buckets, which keeps the recorded values
+	// within ~1.5% of the real ones regardless of magnitude.
+	subBucketBits  = 7
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+)
+
+// LatencyHistogram is a log-linear histogram in the style of HdrHistogram. It records latencies with microsecond
+// resolution in constant memory per order of magnitude, so it can run for the whole duration of a load test.
+type LatencyHistogram struct {
+	mu     sync.Mutex
+	counts []int64
+	total  int64
+	max    time.Duration
+}
+
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{}
+}
+
+// Record adds a single latency to the histogram.
+func (h *LatencyHistogram) Record(d time.Duration) {
+	i := bucketIndex(max(d.Microseconds(), 0))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]int64, i-len(h.counts)+1)...)
+	}
+	h.counts[i]++
+	h.total++
+	h.max = max(h.max, d)
+}
+
+// Count returns the number of recorded latencies.
+func (h *LatencyHistogram) Count() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.total
+}
+
+// ValueAtPercentile returns the highest latency among the lowest p percent (0 < p <= 100) of recorded latencies.
+func (h *LatencyHistogram) ValueAtPercentile(p float64) time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.valueAtPercentile(p)
+}
+
+func (h *LatencyHistogram) valueAtPercentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	if p >= 100 {
+		return h.max
+	}
+
+	target := max(int64(p/100*float64(h.total)+0.5), 1)
+	var seen int64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= target {
+			return min(time.Duration(bucketHighestValue(i))*time.Microsecond, h.max)
+		}
+	}
+	return h.max
+}
+
+// PrintPercentiles writes the percentile distribution in the layout of HdrHistogram's
+// outputPercentileDistribution: value, percentile, cumulative count and 1/(1-percentile).
+func (h *LatencyHistogram) PrintPercentiles(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Value(ms)\tPercentile\tTotalCount\t1/(1-Percentile)\t")
+	for _, p := range []float64{0, 50, 75, 90, 95, 99, 99.9, 99.99, 100} {
+		value := h.valueAtPercentile(max(p, 0.0001))
+		count := int64(p / 100 * float64(h.total))
+		inverse := "inf"
+		if p < 100 {
+			inverse = fmt.Sprintf("%.2f", 1/(1-p/100))
+		}
+		fmt.Fprintf(tw, "%.3f\t%.6f\t%d\t%s\t\n", float64(value.Microseconds())/1000, p/100, count, inverse)
+	}
+	fmt.Fprintf(tw, "#[Max = %.3f, Total count = %d]\t\t\t\t\n", float64(h.max.Microseconds())/1000, h.total)
+	return tw.Flush()
+}
+
+// bucketIndex returns the bucket holding the given number of microseconds. Values below subBucketCount get a
+// bucket each; above that, every power of two is split into subBucketHalf buckets.
+func bucketIndex(v int64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits
+	return shift*subBucketHalf + int(v>>shift)
+}
+
+// bucketHighestValue returns the highest number of microseconds that falls into the given bucket.
+func bucketHighestValue(i int) int64 {
+	if i < subBucketCount {
+		return int64(i)
+	}
+	shift := i/subBucketHalf - 1
+	sub := int64(i - shift*subBucketHalf)
+	return (sub+1)<<shift - 1
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	constantArrival = "constant"
+	poissonArrival  = "poisson"
+	stepArrival     = "step"
+)
+
+// RateStep keeps the arrival rate at Rate requests per second for Duration.
+type RateStep struct {
+	Rate     float64
+	Duration time.Duration
+}
+
+// ArrivalProcess decides when the next customer arrives. Arrivals are open-loop: they don't wait for earlier
+// bookings to finish, so a strategy that slows down builds up a queue instead of lowering the offered load.
+type ArrivalProcess struct {
+	// Kind is one of constant, poisson or step.
+	Kind string
+	// Rate is the number of arrivals per second for constant and poisson arrivals.
+	Rate float64
+	// Steps is the rate schedule for step arrivals. The last step's rate is kept after the schedule ends.
+	Steps []RateStep
+}
+
+// rateAt returns the arrival rate in requests per second after elapsed time.
+func (a ArrivalProcess) rateAt(elapsed time.Duration) float64 {
+	if a.Kind != stepArrival {
+		return a.Rate
+	}
+
+	for _, step := range a.Steps {
+		if elapsed < step.Duration {
+			return step.Rate
+		}
+		elapsed -= step.Duration
+	}
+	if len(a.Steps) == 0 {
+		return 0
+	}
+	return a.Steps[len(a.Steps)-1].Rate
+}
+
+// nextGap returns the time between the arrival at elapsed and the next one. Poisson arrivals have exponentially
+// distributed gaps; constant and step arrivals are evenly spaced.
+func (a ArrivalProcess) nextGap(elapsed time.Duration) time.Duration {
+	rate := a.rateAt(elapsed)
+	if rate <= 0 {
+		return time.Second
+	}
+
+	gap := 1 / rate
+	if a.Kind == poissonArrival {
+		gap = rand.ExpFloat64() / rate
+	}
+	return time.Duration(gap * float64(time.Second))
+}
+
+// parseRateSteps parses a step schedule such as "50:10s,100:10s,200:10s".
+func parseRateSteps(s string) ([]RateStep, error) {
+	var steps []RateStep
+	for part := range strings.SplitSeq(s, ",") {
+		rate, duration, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid step %q, expected rate:duration", part)
+		}
+
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate in step %q: %v", part, err)
+		}
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration in step %q: %v", part, err)
+		}
+		steps = append(steps, RateStep{Rate: r, Duration: d})
+	}
+	return steps, nil
+}
+
+// bookingOutcome classifies how a single booking request ended.
+type bookingOutcome int
+
+const (
+	outcomeBooked bookingOutcome = iota
+	outcomeConflict
+	outcomeSoldOut
+	outcomeError
+	numOutcomes
+)
+
+// LoadTarget books a seat for a customer and reports how it went.
+type LoadTarget func(ctx context.Context, customerID int) bookingOutcome
+
+// inProcessTarget books seats by calling the strategy directly and records every attempt in audit.
+func inProcessTarget(store SeatStore, strategy BookingStrategy, eventID int, policy RetryPolicy, audit *AuditLog) LoadTarget {
+	policy = strategy.retryPolicy(policy)
+	return func(ctx context.Context, customerID int) bookingOutcome {
+		_, _, err := bookWithRetry(store, strategy, eventID, customerID, policy, audit)
+		switch {
+		case err == nil:
+			return outcomeBooked
+		case errors.Is(err, ErrSoldOut):
+			return outcomeSoldOut
+		case isAbort(err), errors.Is(err, sql.ErrNoRows):
+			return outcomeConflict
+		default:
+			return outcomeError
+		}
+	}
+}
+
+// httpTarget books seats through the HTTP API served by BookingServer.
+func httpTarget(client *http.Client, baseURL string, eventID int) LoadTarget {
+	url := fmt.Sprintf("%s/events/%d/bookings", strings.TrimSuffix(baseURL, "/"), eventID)
+	return func(ctx context.Context, customerID int) bookingOutcome {
+		body, err := json.Marshal(bookRequest{CustomerID: customerID})
+		if err != nil {
+			return outcomeError
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return outcomeError
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return outcomeError
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+
+		switch resp.StatusCode {
+		case http.StatusCreated:
+			return outcomeBooked
+		case http.StatusConflict:
+			return outcomeConflict
+		case http.StatusGone:
+			return outcomeSoldOut
+		default:
+			return outcomeError
+		}
+	}
+}
+
+// LoadConfig describes an open-loop load test.
+type LoadConfig struct {
+	Arrival  ArrivalProcess
+	Duration time.Duration
+	// MaxCustomers stops arrivals after this many customers. Zero only stops after Duration.
+	MaxCustomers int
+}
+
+// LoadResult holds the latency histogram and the per-second throughput of a load test.
+type LoadResult struct {
+	Latencies *LatencyHistogram
+	// Offered counts arrivals per second since the start of the test.
+	Offered []int
+	// Completed counts finished bookings per second and outcome.
+	Completed [][numOutcomes]int
+	WallTime  time.Duration
+}
+
+// runLoad sends customers to the target according to the arrival process until the duration passes or the
+// maximum number of customers arrived, then waits for the outstanding bookings. Latency is measured from the
+// scheduled arrival time, so delays in starting a request count against the target instead of being hidden
+// (coordinated omission).
+func runLoad(ctx context.Context, target LoadTarget, cfg LoadConfig) LoadResult {
+	result := LoadResult{Latencies: NewLatencyHistogram()}
+	var mu sync.Mutex
+
+	record := func(second int, f func()) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for len(result.Offered) <= second {
+			result.Offered = append(result.Offered, 0)
+			result.Completed = append(result.Completed, [numOutcomes]int{})
+		}
+		f()
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	next := time.Duration(0)
+	for customerID := 1; cfg.MaxCustomers <= 0 || customerID <= cfg.MaxCustomers; customerID++ {
+		if next >= cfg.Duration {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			result.WallTime = time.Since(start)
+			return result
+		case <-time.After(time.Until(start.Add(next))):
+		}
+
+		scheduled := start.Add(next)
+		arrivalSecond := int(next / time.Second)
+		record(arrivalSecond, func() { result.Offered[arrivalSecond]++ })
+
+		wg.Go(func() {
+			outcome := target(ctx, customerID)
+			finished := time.Now()
+			result.Latencies.Record(finished.Sub(scheduled))
+			second := int(finished.Sub(start) / time.Second)
+			record(second, func() { result.Completed[second][outcome]++ })
+		})
+
+		next += cfg.Arrival.nextGap(next)
+	}
+	wg.Wait()
+
+	result.WallTime = time.Since(start)
+	return result
+}
+
+// printThroughput writes one row per second with the offered load and completed bookings by outcome.
+func printThroughput(w io.Writer, r LoadResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "SECOND\tOFFERED\tCOMPLETED\tBOOKED\tCONFLICT\tSOLD OUT\tERROR\t")
+	for second, offered := range r.Offered {
+		completed := r.Completed[second]
+		total := 0
+		for _, c := range completed {
+			total += c
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t\n", second, offered, total,
+			completed[outcomeBooked], completed[outcomeConflict], completed[outcomeSoldOut], completed[outcomeError])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// MemorySeatStore keeps seats in memory and emulates Postgres READ COMMITTED row locking: reads only see committed
+// assignments, locked seats make FOR UPDATE wait and SKIP LOCKED move on, and a waiting FOR UPDATE re-checks the
+// seat once the lock is released. Every operation can sleep for a simulated round trip, which gives concurrent
+// customers the same chance to interleave as they have against a real database.
+type MemorySeatStore struct {
+	mu sync.Mutex
+	// unlocked is signalled whenever a transaction releases its locks.
+	unlocked *sync.Cond
+	seats    []*memorySeat
+	eventID  int
+	latency  time.Duration
+}
+
+type memorySeat struct {
+	id      int
+	eventID int
+	// customerID is the committed customer, 0 while the seat is free.
+	customerID int
+	lockedBy   *memorySeatTx
+}
+
+// NewMemorySeatStore creates an empty store that sleeps for latency on every operation.
+func NewMemorySeatStore(latency time.Duration) *MemorySeatStore {
+	s := &MemorySeatStore{latency: latency}
+	s.unlocked = sync.NewCond(&s.mu)
+	return s
+}
+
+// roundTrip simulates the network round trip of a database call.
+func (s *MemorySeatStore) roundTrip() {
+	if s.latency > 0 {
+		time.Sleep(s.latency)
+		return
+	}
+	runtime.Gosched()
+}
+
+func (s *MemorySeatStore) Reset(ctx context.Context, numSeats int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.eventID++
+	s.seats = make([]*memorySeat, numSeats)
+	for i := range numSeats {
+		s.seats[i] = &memorySeat{id: i + 1, eventID: s.eventID}
+	}
+	// Wake up transactions still waiting on seats of the previous event.
+	s.unlocked.Broadcast()
+
+	return s.eventID, nil
+}
+
+func (s *MemorySeatStore) Begin(ctx context.Context) (SeatTx, error) {
+	s.roundTrip()
+	return &memorySeatTx{store: s, writes: map[*memorySeat]int{}}, nil
+}
+
+func (s *MemorySeatStore) CountBookedSeats(ctx context.Context, eventID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, seat := range s.seats {
+		if seat.eventID == eventID && seat.customerID != 0 {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemorySeatStore) CountFreeSeats(ctx context.Context, eventID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, seat := range s.seats {
+		if seat.eventID == eventID && seat.customerID == 0 {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemorySeatStore) Assignments(ctx context.Context, eventID int) (map[int]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assignments := map[int]int{}
+	for _, seat := range s.seats {
+		if seat.eventID == eventID && seat.customerID != 0 {
+			assignments[seat.id] = seat.customerID
+		}
+	}
+	return assignments, nil
+}
+
+func (s *MemorySeatStore) FreeSeats(ctx context.Context, eventID int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var seatIDs []int
+	for _, seat := range s.seats {
+		if seat.eventID == eventID && seat.customerID == 0 {
+			seatIDs = append(seatIDs, seat.id)
+		}
+	}
+	return seatIDs, nil
+}
+
+type memorySeatTx struct {
+	store  *MemorySeatStore
+	writes map[*memorySeat]int
+	locked []*memorySeat
+	done   bool
+}
+
+// lock waits until no other transaction holds the seat and then takes its lock. s.mu must be held.
+func (t *memorySeatTx) lock(seat *memorySeat) {
+	for seat.lockedBy != nil && seat.lockedBy != t {
+		t.store.unlocked.Wait()
+	}
+	if seat.lockedBy == nil {
+		seat.lockedBy = t
+		t.locked = append(t.locked, seat)
+	}
+}
+
+func (t *memorySeatTx) FindFreeSeat(ctx context.Context, eventID int, lock LockMode) (int, error) {
+	if t.done {
+		return 0, sql.ErrTxDone
+	}
+	t.store.roundTrip()
+
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	for _, seat := range t.store.seats {
+		if seat.eventID != eventID || seat.customerID != 0 {
+			continue
+		}
+
+		switch lock {
+		case NoLock:
+			return seat.id, nil
+		case LockSkipLocked:
+			if seat.lockedBy != nil && seat.lockedBy != t {
+				continue
+			}
+			t.lock(seat)
+			return seat.id, nil
+		case LockForUpdate:
+			t.lock(seat)
+			// Like Postgres, re-check the seat against the query once the lock is acquired and move on to the
+			// next one if the previous lock holder booked it.
+			if seat.customerID != 0 {
+				continue
+			}
+			return seat.id, nil
+		}
+	}
+
+	return 0, fmt.Errorf("error scanning seat ID: %w", sql.ErrNoRows)
+}
+
+func (t *memorySeatTx) AssignSeat(ctx context.Context, seatID, customerID int) error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.store.roundTrip()
+
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	for _, seat := range t.store.seats {
+		if seat.id == seatID {
+			t.lock(seat)
+			t.writes[seat] = customerID
+			return nil
+		}
+	}
+	return nil
+}
+
+func (t *memorySeatTx) ClaimSeat(ctx context.Context, seatID, customerID int) (bool, error) {
+	if t.done {
+		return false, sql.ErrTxDone
+	}
+	t.store.roundTrip()
+
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	for _, seat := range t.store.seats {
+		if seat.id == seatID {
+			// Like an UPDATE with a WHERE clause, wait for the row lock and re-check the seat.
+			t.lock(seat)
+			if seat.customerID != 0 {
+				return false, nil
+			}
+			t.writes[seat] = customerID
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (t *memorySeatTx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.store.roundTrip()
+
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	for seat, customerID := range t.writes {
+		seat.customerID = customerID
+	}
+	t.release()
+	return nil
+}
+
+func (t *memorySeatTx) Rollback() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+
+	t.store.mu.Lock()
+	defer t.store.mu.Unlock()
+
+	t.release()
+	return nil
+}
+
+// release gives up every lock of the transaction and ends it. s.mu must be held.
+func (t *memorySeatTx) release() {
+	for _, seat := range t.locked {
+		seat.lockedBy = nil
+	}
+	t.locked = nil
+	t.done = true
+	t.store.unlocked.Broadcast()
+}
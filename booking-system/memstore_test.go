@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemorySeatStoreSkipLocked(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemorySeatStore(0)
+	eventID, _ := store.Reset(ctx, 2)
+
+	tx1, _ := store.Begin(ctx)
+	defer tx1.Rollback()
+	seat1, err := tx1.FindFreeSeat(ctx, eventID, LockForUpdate)
+	if err != nil {
+		t.Fatalf("error finding seat: %v", err)
+	}
+
+	tx2, _ := store.Begin(ctx)
+	defer tx2.Rollback()
+	seat2, err := tx2.FindFreeSeat(ctx, eventID, LockSkipLocked)
+	if err != nil {
+		t.Fatalf("error finding seat: %v", err)
+	}
+	if seat1 == seat2 {
+		t.Fatalf("SKIP LOCKED returned seat %d, which is locked by another transaction", seat2)
+	}
+
+	tx3, _ := store.Begin(ctx)
+	defer tx3.Rollback()
+	_, err = tx3.FindFreeSeat(ctx, eventID, LockSkipLocked)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows when every free seat is locked, got %v", err)
+	}
+}
+
+func TestMemorySeatStoreForUpdateWaitsAndRechecks(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemorySeatStore(0)
+	eventID, _ := store.Reset(ctx, 2)
+
+	tx1, _ := store.Begin(ctx)
+	seat1, _ := tx1.FindFreeSeat(ctx, eventID, LockForUpdate)
+	tx1.AssignSeat(ctx, seat1, 1)
+
+	found := make(chan int)
+	go func() {
+		tx2, _ := store.Begin(ctx)
+		defer tx2.Rollback()
+		seat, _ := tx2.FindFreeSeat(ctx, eventID, LockForUpdate)
+		found <- seat
+	}()
+
+	select {
+	case seat := <-found:
+		t.Fatalf("FOR UPDATE returned seat %d while it was locked", seat)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	err := tx1.Commit()
+	if err != nil {
+		t.Fatalf("error committing: %v", err)
+	}
+
+	seat2 := <-found
+	if seat2 == seat1 {
+		t.Fatalf("FOR UPDATE returned seat %d after it was booked", seat2)
+	}
+}
+
+func TestMemorySeatStoreHidesUncommittedAssignments(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemorySeatStore(0)
+	eventID, _ := store.Reset(ctx, 1)
+
+	tx, _ := store.Begin(ctx)
+	tx.AssignSeat(ctx, 1, 1)
+
+	booked, _ := store.CountBookedSeats(ctx, eventID)
+	if booked != 0 {
+		t.Fatalf("expected uncommitted assignment to be invisible, got %d booked seats", booked)
+	}
+
+	tx.Rollback()
+	booked, _ = store.CountBookedSeats(ctx, eventID)
+	if booked != 0 {
+		t.Fatalf("expected rolled back assignment to be discarded, got %d booked seats", booked)
+	}
+}
+
+func TestStrategiesOnMemoryStore(t *testing.T) {
+	cfg := RunConfig{NumSeats: 50, NumCustomers: 50, Retry: noRetry}
+
+	for _, name := range []string{"locked", "locked-optimized", "queue"} {
+		t.Run(name, func(t *testing.T) {
+			strategy, err := findStrategy(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := runStrategy(NewMemorySeatStore(time.Millisecond), strategy, cfg)
+			if err != nil {
+				t.Fatalf("error running strategy: %v", err)
+			}
+			if result.SeatsAssigned != cfg.NumSeats {
+				t.Errorf("expected %d seats assigned, got %d", cfg.NumSeats, result.SeatsAssigned)
+			}
+			if len(result.Violations) > 0 {
+				t.Errorf("expected no violations, got %v", result.Violations)
+			}
+		})
+	}
+
+	t.Run("naive", func(t *testing.T) {
+		strategy, _ := findStrategy("naive")
+
+		result, err := runStrategy(NewMemorySeatStore(time.Millisecond), strategy, cfg)
+		if err != nil {
+			t.Fatalf("error running strategy: %v", err)
+		}
+		if len(result.Violations) == 0 {
+			t.Errorf("expected lost updates without locks, got %d seats assigned and no violations", result.SeatsAssigned)
+		}
+	})
+}
+
+func TestQueueSkipsSeatsBookedBehindAllocator(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemorySeatStore(0)
+	eventID, _ := store.Reset(ctx, 2)
+
+	stop, err := startAllocator(ctx, store, eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// Book the first seat without going through the allocator, like a group booking would.
+	tx, _ := store.Begin(ctx)
+	tx.AssignSeat(ctx, 1, 100)
+	tx.Commit()
+
+	seatID, err := bookSeatQueued(store, eventID, 1)
+	if err != nil {
+		t.Fatalf("error booking seat: %v", err)
+	}
+	if seatID != 2 {
+		t.Fatalf("expected seat 2, got %d", seatID)
+	}
+
+	_, err = bookSeatQueued(store, eventID, 2)
+	if !errors.Is(err, ErrSoldOut) {
+		t.Fatalf("expected ErrSoldOut, got %v", err)
+	}
+
+	assignments, _ := store.Assignments(ctx, eventID)
+	if assignments[1] != 100 {
+		t.Errorf("expected seat 1 to stay with customer 100, got %d", assignments[1])
+	}
+}
+
+func TestQueueRebooksReleasedSeats(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemorySeatStore(0)
+	eventID, _ := store.Reset(ctx, 1)
+
+	stop, err := startAllocator(ctx, store, eventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	seatID, err := bookSeatQueued(store, eventID, 1)
+	if err != nil {
+		t.Fatalf("error booking seat: %v", err)
+	}
+
+	// Cancel the booking and hand the seat back, like the server does.
+	tx, _ := store.Begin(ctx)
+	tx.AssignSeat(ctx, seatID, 0)
+	tx.Commit()
+	releaseQueued(store, eventID, seatID)
+
+	rebooked, err := bookSeatQueued(store, eventID, 2)
+	if err != nil {
+		t.Fatalf("error booking cancelled seat: %v", err)
+	}
+	if rebooked != seatID {
+		t.Fatalf("expected seat %d, got %d", seatID, rebooked)
+	}
+}
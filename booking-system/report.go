@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// RunConfig describes the workload of a single strategy run.
+type RunConfig struct {
+	NumSeats     int
+	NumCustomers int
+	Retry        RetryPolicy
+	// Store is the kind of SeatStore to book against: postgres or memory.
+	Store string
+	// Latency is the simulated round trip of every in-memory store operation.
+	Latency time.Duration
+}
+
+const (
+	postgresStore = "postgres"
+	memoryStore   = "memory"
+)
+
+// newSeatStore returns the store selected by cfg.
+func newSeatStore(db *sql.DB, cfg RunConfig) (SeatStore, error) {
+	switch cfg.Store {
+	case postgresStore:
+		return NewPostgresSeatStore(db), nil
+	case memoryStore:
+		return NewMemorySeatStore(cfg.Latency), nil
+	default:
+		return nil, fmt.Errorf("invalid store %q. use postgres or memory", cfg.Store)
+	}
+}
+
+// RunResult holds the measurements of a single strategy run. Latencies, Attempts and Errors are indexed by
+// customer ID - 1.
+type RunResult struct {
+	Strategy       string
+	WallTime       time.Duration
+	SeatsAssigned  int
+	FailedAttempts int
+	Latencies      []time.Duration
+	Attempts       []BookingAttempts
+	Errors         []error
+	Violations     []Violation
+}
+
+// TotalRetries returns the number of attempts made on top of the first one for each customer.
+func (r RunResult) TotalRetries() int {
+	retries := 0
+	for _, a := range r.Attempts {
+		retries += a.Total - 1
+	}
+	return retries
+}
+
+// TotalAborts returns the number of attempts Postgres aborted because of a conflicting transaction.
+func (r RunResult) TotalAborts() int {
+	aborts := 0
+	for _, a := range r.Attempts {
+		aborts += a.Aborts
+	}
+	return aborts
+}
+
+// MaxAttempts returns the highest number of attempts any single customer needed.
+func (r RunResult) MaxAttempts() int {
+	maxAttempts := 0
+	for _, a := range r.Attempts {
+		maxAttempts = max(maxAttempts, a.Total)
+	}
+	return maxAttempts
+}
+
+// runStrategy resets the store, lets cfg.NumCustomers goroutines book a seat concurrently using the given
+// strategy and collects the results.
+func runStrategy(store SeatStore, strategy BookingStrategy, cfg RunConfig) (RunResult, error) {
+	ctx := context.Background()
+
+	eventID, err := store.Reset(ctx, cfg.NumSeats)
+	if err != nil {
+		return RunResult{}, fmt.Errorf("error resetting seats: %v", err)
+	}
+
+	stop, err := strategy.start(ctx, store, eventID)
+	if err != nil {
+		return RunResult{}, fmt.Errorf("error starting strategy: %v", err)
+	}
+	defer stop()
+
+	latencies := make([]time.Duration, cfg.NumCustomers)
+	attempts := make([]BookingAttempts, cfg.NumCustomers)
+	errs := make([]error, cfg.NumCustomers)
+	policy := strategy.retryPolicy(cfg.Retry)
+	audit := NewAuditLog()
+
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := range cfg.NumCustomers {
+		wg.Go(func() {
+			bookingStart := time.Now()
+			_, attempts[i], errs[i] = bookWithRetry(store, strategy, eventID, i+1, policy, audit)
+			latencies[i] = time.Since(bookingStart)
+		})
+	}
+	wg.Wait()
+
+	wallTime := time.Since(start)
+
+	bookedSeats, err := store.CountBookedSeats(ctx, eventID)
+	if err != nil {
+		return RunResult{}, fmt.Errorf("error counting booked seats: %v", err)
+	}
+
+	assignments, err := store.Assignments(ctx, eventID)
+	if err != nil {
+		return RunResult{}, fmt.Errorf("error verifying bookings: %v", err)
+	}
+	violations := checkInvariants(audit.Attempts(), assignments)
+
+	if pg, ok := store.(*PostgresSeatStore); ok {
+		err = audit.Flush(pg.db)
+		if err != nil {
+			return RunResult{}, err
+		}
+	}
+
+	failedAttempts := 0
+	for _, err := range errs {
+		if err != nil {
+			failedAttempts++
+		}
+	}
+
+	return RunResult{
+		Strategy:       strategy.Name,
+		WallTime:       wallTime,
+		SeatsAssigned:  bookedSeats,
+		FailedAttempts: failedAttempts,
+		Latencies:      latencies,
+		Attempts:       attempts,
+		Errors:         errs,
+		Violations:     violations,
+	}, nil
+}
+
+// percentile returns the nearest-rank p-th percentile (0 < p <= 100) of the given durations.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+
+	rank := int(p/100*float64(len(sorted)) + 0.5)
+	rank = min(max(rank, 1), len(sorted))
+	return sorted[rank-1]
+}
+
+// printReport writes the results as an aligned table, one row per strategy.
+func printReport(w io.Writer, results []RunResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STRATEGY\tWALL TIME\tSEATS ASSIGNED\tFAILED\tVIOLATIONS\tRETRIES\tABORTS\tMAX ATTEMPTS\tP50\tP95\tP99")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%v\t%d\t%d\t%d\t%d\t%d\t%d\t%v\t%v\t%v\n",
+			r.Strategy,
+			r.WallTime.Round(time.Millisecond),
+			r.SeatsAssigned,
+			r.FailedAttempts,
+			len(r.Violations),
+			r.TotalRetries(),
+			r.TotalAborts(),
+			r.MaxAttempts(),
+			percentile(r.Latencies, 50).Round(time.Microsecond),
+			percentile(r.Latencies, 95).Round(time.Microsecond),
+			percentile(r.Latencies, 99).Round(time.Microsecond),
+		)
+	}
+	return tw.Flush()
+}
+
+// printAttempts writes one line for every customer that needed more than one attempt or did not get a seat.
+func printAttempts(w io.Writer, r RunResult) {
+	for i, attempts := range r.Attempts {
+		err := r.Errors[i]
+		switch {
+		case err != nil:
+			fmt.Fprintf(w, "customer %d: failed after %d attempt(s), %d aborted: %v\n", i+1, attempts.Total, attempts.Aborts, err)
+		case attempts.Total > 1:
+			fmt.Fprintf(w, "customer %d: booked after %d attempts, %d aborted\n", i+1, attempts.Total, attempts.Aborts)
+		}
+	}
+}
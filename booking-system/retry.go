@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	serializationFailure pq.ErrorCode = "40001"
+	deadlockDetected     pq.ErrorCode = "40P01"
+)
+
+var (
+	// ErrSoldOut is returned when a booking fails because there are no free seats left.
+	ErrSoldOut = errors.New("no free seats left")
+	// ErrVersionConflict is returned when an optimistic update finds that the seat changed since it was read.
+	ErrVersionConflict = errors.New("seat was modified concurrently")
+)
+
+// RetryPolicy bounds how often and how long a failed booking is retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one. A value <= 1 disables retries.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var noRetry = RetryPolicy{MaxAttempts: 1}
+
+// backoff returns the delay before the given retry (1 for the first retry) using exponential backoff
+// capped at MaxDelay with full jitter.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.MaxDelay
+	if shift := retry - 1; shift < 32 {
+		delay = min(p.BaseDelay<<shift, p.MaxDelay)
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return rand.N(delay + 1)
+}
+
+// isRetryable reports whether a failed booking attempt is worth retrying. Serialization failures, deadlocks and
+// version conflicts mean another customer won the race, but say nothing about seat availability. An empty scan
+// is only retried while free seats remain, because FOR UPDATE SKIP LOCKED and LIMIT can return no row even
+// though a seat is free.
+func isRetryable(store SeatStore, eventID int, err error) (bool, error) {
+	if isAbort(err) {
+		return true, nil
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		freeSeats, countErr := store.CountFreeSeats(context.Background(), eventID)
+		if countErr != nil {
+			return false, countErr
+		}
+		return freeSeats > 0, nil
+	}
+
+	return false, nil
+}
+
+// isAbort reports whether the attempt was aborted because it conflicted with another one, either by Postgres or
+// by a failed compare-and-swap.
+func isAbort(err error) bool {
+	if errors.Is(err, ErrVersionConflict) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && (pqErr.Code == serializationFailure || pqErr.Code == deadlockDetected)
+}
+
+// BookingAttempts counts what happened while booking a seat for one customer.
+type BookingAttempts struct {
+	// Total is the number of attempts made, including the successful one.
+	Total int
+	// Aborts is the number of attempts that lost a conflict: a serialization failure, a deadlock or a version
+	// conflict.
+	Aborts int
+}
+
+// bookWithRetry books a seat of the event for the given customer, retrying retryable failures according to the
+// policy. Every attempt is recorded in audit. It returns the booked seat ID.
+func bookWithRetry(store SeatStore, strategy BookingStrategy, eventID, customerID int, policy RetryPolicy, audit *AuditLog) (int, BookingAttempts, error) {
+	var attempts BookingAttempts
+	for {
+		attempts.Total++
+		startedAt := time.Now()
+		seatID, err := strategy.Book(store, eventID, customerID)
+		audit.Record(BookingAttempt{
+			EventID:    eventID,
+			CustomerID: customerID,
+			SeatID:     seatID,
+			Err:        err,
+			StartedAt:  startedAt,
+			FinishedAt: time.Now(),
+		})
+		if err == nil {
+			return seatID, attempts, nil
+		}
+		if isAbort(err) {
+			attempts.Aborts++
+		}
+
+		retryable, classifyErr := isRetryable(store, eventID, err)
+		if classifyErr != nil {
+			return 0, attempts, fmt.Errorf("error classifying %v: %v", err, classifyErr)
+		}
+		if !retryable {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, attempts, fmt.Errorf("%w: %v", ErrSoldOut, err)
+			}
+			return 0, attempts, err
+		}
+		if attempts.Total >= policy.MaxAttempts {
+			return 0, attempts, fmt.Errorf("giving up after %d attempts: %w", attempts.Total, err)
+		}
+
+		time.Sleep(policy.backoff(attempts.Total))
+	}
+}
+
+// countFreeSeats returns the number of seats of the event that are neither assigned to a customer nor held.
+func countFreeSeats(db *sql.DB, eventID int) (int, error) {
+	var count int
+	err := db.QueryRow(
+		"SELECT COUNT(*) FROM bookings WHERE event_id = $1 AND customer_id IS NULL AND hold_id IS NULL", eventID,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error scanning count: %v", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrEventNotFound is returned when a request refers to an event that does not exist.
+	ErrEventNotFound = errors.New("event not found")
+	// ErrBookingNotFound is returned when cancelling a seat that is not booked.
+	ErrBookingNotFound = errors.New("booking not found")
+)
+
+// BookingServer exposes the booking strategies over HTTP. Every server instance books single seats with one
+// strategy. Booking attempts are audited in memory; RunAuditFlusher and FlushAudit write them to the database.
+type BookingServer struct {
+	db       *sql.DB
+	store    *PostgresSeatStore
+	strategy BookingStrategy
+	retry    RetryPolicy
+	audit    *AuditLog
+	mux      *http.ServeMux
+
+	// started holds the events the strategy has been started for.
+	startedMu sync.Mutex
+	started   map[int]bool
+}
+
+func NewBookingServer(db *sql.DB, strategy BookingStrategy, retry RetryPolicy) *BookingServer {
+	s := &BookingServer{
+		db:       db,
+		store:    NewPostgresSeatStore(db),
+		strategy: strategy,
+		retry:    strategy.retryPolicy(retry),
+		audit:    NewAuditLog(),
+		mux:      http.NewServeMux(),
+		started:  map[int]bool{},
+	}
+
+	s.mux.HandleFunc("POST /events/{id}/bookings", s.handleBook)
+	s.mux.HandleFunc("GET /events/{id}/seats", s.handleListSeats)
+	s.mux.HandleFunc("DELETE /bookings/{id}", s.handleCancel)
+
+	return s
+}
+
+// RunAuditFlusher writes the audited booking attempts to the database every interval until ctx is cancelled, so
+// that requests don't wait for the audit log.
+func (s *BookingServer) RunAuditFlusher(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.FlushAudit()
+		}
+	}
+}
+
+// FlushAudit writes the audited booking attempts to the database.
+func (s *BookingServer) FlushAudit() {
+	err := s.audit.Flush(s.db)
+	if err != nil {
+		log.Printf("error writing audit log: %v", err)
+	}
+}
+
+func (s *BookingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// startEvent starts the strategy for an event the first time one of its seats is booked. The strategy keeps
+// running until the server exits.
+func (s *BookingServer) startEvent(ctx context.Context, eventID int) error {
+	s.startedMu.Lock()
+	defer s.startedMu.Unlock()
+
+	if s.started[eventID] {
+		return nil
+	}
+	_, err := s.strategy.start(ctx, s.store, eventID)
+	if err != nil {
+		return fmt.Errorf("error starting strategy: %v", err)
+	}
+	s.started[eventID] = true
+	return nil
+}
+
+type bookRequest struct {
+	CustomerID int `json:"customer_id"`
+	// Seats is the number of adjacent seats to book at once. Zero books a single seat.
+	Seats int `json:"seats"`
+}
+
+type seatResponse struct {
+	BookingID  int    `json:"booking_id"`
+	EventID    int    `json:"event_id"`
+	Section    string `json:"section"`
+	Row        int    `json:"row"`
+	Seat       int    `json:"seat"`
+	CustomerID *int   `json:"customer_id,omitempty"`
+	Status     string `json:"status"`
+}
+
+type bookResponse struct {
+	Bookings []seatResponse `json:"bookings"`
+	Attempts int            `json:"attempts"`
+}
+
+// handleBook books one seat with the server's strategy, or a group of adjacent seats all-or-nothing.
+func (s *BookingServer) handleBook(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid event ID: %v", err))
+		return
+	}
+
+	var req bookRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %v", err))
+		return
+	}
+	if req.CustomerID <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("customer_id is required"))
+		return
+	}
+
+	err = checkEventExists(s.db, eventID)
+	if err != nil {
+		writeBookingError(w, err)
+		return
+	}
+
+	var seatIDs []int
+	var attempts int
+	if req.Seats > 1 {
+		seatIDs, attempts, err = s.bookGroup(eventID, req.CustomerID, req.Seats)
+	} else {
+		err = s.startEvent(r.Context(), eventID)
+		if err != nil {
+			writeBookingError(w, err)
+			return
+		}
+
+		var seatID int
+		var stats BookingAttempts
+		seatID, stats, err = bookWithRetry(s.store, s.strategy, eventID, req.CustomerID, s.retry, s.audit)
+		seatIDs, attempts = []int{seatID}, stats.Total
+	}
+	if err != nil {
+		writeBookingError(w, err)
+		return
+	}
+
+	resp := bookResponse{Attempts: attempts}
+	for _, seatID := range seatIDs {
+		seat, err := getSeat(s.db, seatID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		resp.Bookings = append(resp.Bookings, seat)
+	}
+
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// bookGroup books adjacent seats, retrying when another customer took some of them first. Every seat booked is
+// recorded in the audit log as part of a group, so that verify doesn't report the customer as double-booked.
+func (s *BookingServer) bookGroup(eventID, customerID, numSeats int) ([]int, int, error) {
+	attempts := 0
+	for {
+		attempts++
+		startedAt := time.Now()
+		seats, err := bookGroup(s.db, eventID, customerID, numSeats)
+		if err == nil {
+			finishedAt := time.Now()
+			seatIDs := make([]int, len(seats))
+			for i, seat := range seats {
+				seatIDs[i] = seat.ID
+				s.audit.Record(BookingAttempt{
+					EventID:    eventID,
+					CustomerID: customerID,
+					SeatID:     seat.ID,
+					Group:      true,
+					StartedAt:  startedAt,
+					FinishedAt: finishedAt,
+				})
+			}
+			return seatIDs, attempts, nil
+		}
+		if !errors.Is(err, ErrSeatsTaken) && !isAbort(err) || attempts >= max(s.retry.MaxAttempts, 1) {
+			return nil, attempts, err
+		}
+	}
+}
+
+// handleListSeats lists every seat of the event with its status.
+func (s *BookingServer) handleListSeats(w http.ResponseWriter, r *http.Request) {
+	eventID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid event ID: %v", err))
+		return
+	}
+
+	err = checkEventExists(s.db, eventID)
+	if err != nil {
+		writeBookingError(w, err)
+		return
+	}
+
+	seats, err := listSeats(s.db, eventID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, seats)
+}
+
+// handleCancel frees a booked seat. The booking ID is the seat ID returned when booking.
+func (s *BookingServer) handleCancel(w http.ResponseWriter, r *http.Request) {
+	seatID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid booking ID: %v", err))
+		return
+	}
+
+	eventID, err := cancelBooking(s.db, seatID)
+	if err != nil {
+		writeBookingError(w, err)
+		return
+	}
+
+	s.startedMu.Lock()
+	started := s.started[eventID]
+	s.startedMu.Unlock()
+	if started {
+		s.strategy.release(s.store, eventID, seatID)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeBookingError maps booking errors to status codes: unknown events and bookings are 404, losing a race
+// against another customer is 409 and running out of seats is 410.
+func writeBookingError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrEventNotFound), errors.Is(err, ErrBookingNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrSoldOut), errors.Is(err, ErrNotEnoughSeats):
+		writeError(w, http.StatusGone, err)
+	case isAbort(err), errors.Is(err, ErrSeatsTaken), errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusConflict, err)
+	default:
+		log.Printf("error handling booking request: %v", err)
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+func checkEventExists(db *sql.DB, eventID int) error {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM events WHERE event_id = $1)", eventID).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error scanning event: %v", err)
+	}
+	if !exists {
+		return fmt.Errorf("event %d: %w", eventID, ErrEventNotFound)
+	}
+	return nil
+}
+
+const seatColumns = `
+	b.seat_id, b.event_id, s.name, b.row_num, b.seat_num, b.customer_id,
+	CASE WHEN b.customer_id IS NOT NULL THEN 'booked' WHEN b.hold_id IS NOT NULL THEN 'held' ELSE 'free' END
+`
+
+func scanSeat(row interface{ Scan(...any) error }) (seatResponse, error) {
+	var seat seatResponse
+	var customerID sql.NullInt64
+	err := row.Scan(&seat.BookingID, &seat.EventID, &seat.Section, &seat.Row, &seat.Seat, &customerID, &seat.Status)
+	if err != nil {
+		return seatResponse{}, err
+	}
+	if customerID.Valid {
+		id := int(customerID.Int64)
+		seat.CustomerID = &id
+	}
+	return seat, nil
+}
+
+func getSeat(db *sql.DB, seatID int) (seatResponse, error) {
+	row := db.QueryRow(`
+		SELECT `+seatColumns+` FROM bookings b JOIN sections s ON s.section_id = b.section_id WHERE b.seat_id = $1
+	`, seatID)
+	seat, err := scanSeat(row)
+	if err != nil {
+		return seatResponse{}, fmt.Errorf("error scanning seat: %v", err)
+	}
+	return seat, nil
+}
+
+func listSeats(db *sql.DB, eventID int) ([]seatResponse, error) {
+	rows, err := db.Query(`
+		SELECT `+seatColumns+` FROM bookings b JOIN sections s ON s.section_id = b.section_id
+		WHERE b.event_id = $1 ORDER BY b.section_id, b.row_num, b.seat_num
+	`, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying seats: %v", err)
+	}
+	defer rows.Close()
+
+	seats := []seatResponse{}
+	for rows.Next() {
+		seat, err := scanSeat(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning seat: %v", err)
+		}
+		seats = append(seats, seat)
+	}
+	return seats, rows.Err()
+}
+
+// cancelBooking frees a booked seat so that it can be booked again. It returns the seat's event.
+func cancelBooking(db *sql.DB, seatID int) (int, error) {
+	var eventID int
+	err := db.QueryRow(`
+		UPDATE bookings SET customer_id = NULL, version = version + 1 WHERE seat_id = $1 AND customer_id IS NOT NULL
+		RETURNING event_id
+	`, seatID).Scan(&eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("booking %d: %w", seatID, ErrBookingNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error cancelling booking: %v", err)
+	}
+	return eventID, nil
+}
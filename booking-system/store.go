@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrUnsupportedStore is returned by strategies that rely on SQL features a SeatStore doesn't offer.
+var ErrUnsupportedStore = errors.New("strategy is not supported by this store")
+
+// LockMode is the row lock taken when looking for a free seat.
+type LockMode int
+
+const (
+	// NoLock reads the seat without locking it, like a plain SELECT.
+	NoLock LockMode = iota
+	// LockForUpdate locks the seat, waiting for other transactions holding it, like SELECT ... FOR UPDATE.
+	LockForUpdate
+	// LockSkipLocked locks the seat, skipping seats other transactions hold, like SELECT ... FOR UPDATE SKIP LOCKED.
+	LockSkipLocked
+)
+
+// SeatStore holds the seats of events. Strategies written against it run unchanged on Postgres and in memory.
+type SeatStore interface {
+	// Reset removes every event and creates a single event with numSeats free seats. It returns the event ID.
+	Reset(ctx context.Context, numSeats int) (int, error)
+	// Begin starts a READ COMMITTED transaction.
+	Begin(ctx context.Context) (SeatTx, error)
+	// CountBookedSeats returns the number of seats of the event assigned to a customer.
+	CountBookedSeats(ctx context.Context, eventID int) (int, error)
+	// CountFreeSeats returns the number of seats of the event that are neither assigned nor held.
+	CountFreeSeats(ctx context.Context, eventID int) (int, error)
+	// Assignments returns the customer of every booked seat of the event, keyed by seat ID.
+	Assignments(ctx context.Context, eventID int) (map[int]int, error)
+	// FreeSeats returns the IDs of the seats of the event that are neither assigned nor held, in order.
+	FreeSeats(ctx context.Context, eventID int) ([]int, error)
+}
+
+// SeatTx is a transaction on a SeatStore. Locks taken by FindFreeSeat and AssignSeat are held until Commit or
+// Rollback.
+type SeatTx interface {
+	// FindFreeSeat returns a free seat of the event. It returns an error wrapping sql.ErrNoRows if no seat is found.
+	FindFreeSeat(ctx context.Context, eventID int, lock LockMode) (int, error)
+	// AssignSeat assigns the seat to the customer, whether or not it is still free, like a plain UPDATE.
+	AssignSeat(ctx context.Context, seatID, customerID int) error
+	// ClaimSeat assigns the seat to the customer only if it is neither assigned nor held. It reports whether it
+	// did.
+	ClaimSeat(ctx context.Context, seatID, customerID int) (bool, error)
+	Commit() error
+	Rollback() error
+}
+
+// PostgresSeatStore stores seats in the `bookings` table.
+type PostgresSeatStore struct {
+	db *sql.DB
+}
+
+func NewPostgresSeatStore(db *sql.DB) *PostgresSeatStore {
+	return &PostgresSeatStore{db: db}
+}
+
+func (s *PostgresSeatStore) Reset(ctx context.Context, numSeats int) (int, error) {
+	err := setupDatabase(s.db)
+	if err != nil {
+		return 0, fmt.Errorf("error setting up database: %v", err)
+	}
+	return generateSeats(s.db, numSeats)
+}
+
+func (s *PostgresSeatStore) Begin(ctx context.Context) (SeatTx, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %v", err)
+	}
+	return &postgresSeatTx{tx: tx}, nil
+}
+
+func (s *PostgresSeatStore) CountBookedSeats(ctx context.Context, eventID int) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM bookings WHERE event_id = $1 AND customer_id IS NOT NULL", eventID,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error scanning count: %v", err)
+	}
+	return count, nil
+}
+
+func (s *PostgresSeatStore) CountFreeSeats(ctx context.Context, eventID int) (int, error) {
+	return countFreeSeats(s.db, eventID)
+}
+
+func (s *PostgresSeatStore) Assignments(ctx context.Context, eventID int) (map[int]int, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT seat_id, customer_id FROM bookings WHERE event_id = $1 AND customer_id IS NOT NULL", eventID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying assignments: %v", err)
+	}
+	defer rows.Close()
+
+	assignments := map[int]int{}
+	for rows.Next() {
+		var seatID, customerID int
+		err = rows.Scan(&seatID, &customerID)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning assignment: %v", err)
+		}
+		assignments[seatID] = customerID
+	}
+	return assignments, rows.Err()
+}
+
+func (s *PostgresSeatStore) FreeSeats(ctx context.Context, eventID int) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT seat_id FROM bookings WHERE event_id = $1 AND customer_id IS NULL AND hold_id IS NULL ORDER BY seat_id
+	`, eventID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying free seats: %v", err)
+	}
+	defer rows.Close()
+
+	var seatIDs []int
+	for rows.Next() {
+		var seatID int
+		err = rows.Scan(&seatID)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning seat ID: %v", err)
+		}
+		seatIDs = append(seatIDs, seatID)
+	}
+	return seatIDs, rows.Err()
+}
+
+type postgresSeatTx struct {
+	tx *sql.Tx
+}
+
+func (t *postgresSeatTx) FindFreeSeat(ctx context.Context, eventID int, lock LockMode) (int, error) {
+	query := "SELECT seat_id FROM bookings WHERE event_id = $1 AND customer_id IS NULL AND hold_id IS NULL"
+	switch lock {
+	case LockForUpdate:
+		query += " FOR UPDATE"
+	case LockSkipLocked:
+		query += " FOR UPDATE SKIP LOCKED"
+	}
+	query += " LIMIT 1"
+
+	var seatID int
+	err := t.tx.QueryRowContext(ctx, query, eventID).Scan(&seatID)
+	if err != nil {
+		return 0, fmt.Errorf("error scanning seat ID: %w", err)
+	}
+	return seatID, nil
+}
+
+func (t *postgresSeatTx) AssignSeat(ctx context.Context, seatID, customerID int) error {
+	_, err := t.tx.ExecContext(ctx, "UPDATE bookings SET customer_id = $1 WHERE seat_id = $2", customerID, seatID)
+	if err != nil {
+		return fmt.Errorf("error updating booking: %w", err)
+	}
+	return nil
+}
+
+func (t *postgresSeatTx) ClaimSeat(ctx context.Context, seatID, customerID int) (bool, error) {
+	result, err := t.tx.ExecContext(ctx, `
+		UPDATE bookings SET customer_id = $1 WHERE seat_id = $2 AND customer_id IS NULL AND hold_id IS NULL
+	`, customerID, seatID)
+	if err != nil {
+		return false, fmt.Errorf("error updating booking: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting affected rows: %w", err)
+	}
+	return n == 1, nil
+}
+
+func (t *postgresSeatTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *postgresSeatTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// postgresOnly adapts a strategy that needs raw SQL to the SeatStore signature. It fails with ErrUnsupportedStore
+// on any other store.
+func postgresOnly(book func(db *sql.DB, eventID, customerID int) (int, error)) func(SeatStore, int, int) (int, error) {
+	return func(store SeatStore, eventID, customerID int) (int, error) {
+		pg, ok := store.(*PostgresSeatStore)
+		if !ok {
+			return 0, ErrUnsupportedStore
+		}
+		return book(pg.db, eventID, customerID)
+	}
+}
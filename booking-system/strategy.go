@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BookingStrategy is a named way of assigning an empty seat of an event to a customer.
+type BookingStrategy struct {
+	Name string
+	// Book assigns a free seat of the event to the customer and returns the seat ID.
+	Book func(store SeatStore, eventID, customerID int) (int, error)
+	// PostgresOnly is set for strategies that need raw SQL and can't run on other stores.
+	PostgresOnly bool
+	// Retry is the minimum retry policy the strategy needs to be useful at all. Strategies that rely on aborting or
+	// losing conflicting attempts set it so that they retry even when retries are disabled.
+	Retry RetryPolicy
+	// Start prepares an event for booking, e.g. by starting background goroutines, and returns a function that
+	// cleans them up. Strategies that book straight against the store leave it nil.
+	Start func(ctx context.Context, store SeatStore, eventID int) (func(), error)
+	// Release tells a started strategy that a booked seat of the event was freed and can be booked again.
+	// Strategies that find free seats in the store leave it nil.
+	Release func(store SeatStore, eventID, seatID int)
+}
+
+// conflictRetry lets every customer retry until the conflicting transactions ahead of them have committed.
+var conflictRetry = RetryPolicy{MaxAttempts: 200, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+// retryPolicy returns the given policy, or the strategy's own policy if it allows more attempts.
+func (s BookingStrategy) retryPolicy(policy RetryPolicy) RetryPolicy {
+	if s.Retry.MaxAttempts > policy.MaxAttempts {
+		return s.Retry
+	}
+	return policy
+}
+
+// start runs the strategy's Start function, if it has one.
+func (s BookingStrategy) start(ctx context.Context, store SeatStore, eventID int) (func(), error) {
+	if s.Start == nil {
+		return func() {}, nil
+	}
+	return s.Start(ctx, store, eventID)
+}
+
+// release runs the strategy's Release function, if it has one.
+func (s BookingStrategy) release(store SeatStore, eventID, seatID int) {
+	if s.Release != nil {
+		s.Release(store, eventID, seatID)
+	}
+}
+
+// bookingStrategies lists every available strategy in the order they are reported.
+var bookingStrategies = []BookingStrategy{
+	{Name: "naive", Book: bookSeatNaive},
+	{Name: "subquery", Book: postgresOnly(bookSeatSubquery), PostgresOnly: true},
+	{Name: "locked", Book: bookSeatLocked},
+	{Name: "locked-optimized", Book: bookSeatLockedOptimized},
+	{Name: "repeatable-read", Book: postgresOnly(bookSeatRepeatableRead), PostgresOnly: true, Retry: conflictRetry},
+	{Name: "serializable", Book: postgresOnly(bookSeatSerializable), PostgresOnly: true, Retry: conflictRetry},
+	{Name: "optimistic", Book: postgresOnly(bookSeatOptimistic), PostgresOnly: true, Retry: conflictRetry},
+	{Name: "advisory-lock", Book: postgresOnly(bookSeatAdvisoryLock), PostgresOnly: true},
+	{Name: "queue", Book: bookSeatQueued, Start: startAllocator, Release: releaseQueued},
+}
+
+// strategyNames returns the names of all registered strategies.
+func strategyNames() []string {
+	names := make([]string, len(bookingStrategies))
+	for i, s := range bookingStrategies {
+		names[i] = s.Name
+	}
+	return names
+}
+
+// findStrategy returns the registered strategy with the given name.
+func findStrategy(name string) (BookingStrategy, error) {
+	for _, s := range bookingStrategies {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return BookingStrategy{}, fmt.Errorf("unknown strategy %q, available: %s", name, strings.Join(strategyNames(), ", "))
+}
+
+// selectStrategies parses a comma-separated list of strategy names. An empty list selects every strategy.
+func selectStrategies(list string) ([]BookingStrategy, error) {
+	if strings.TrimSpace(list) == "" {
+		return bookingStrategies, nil
+	}
+
+	var selected []BookingStrategy
+	for name := range strings.SplitSeq(list, ",") {
+		s, err := findStrategy(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		selected = append(selected, s)
+	}
+	return selected, nil
+}
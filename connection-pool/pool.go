@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrPoolExhausted is returned by TakeContext when no connection became available within the pool's maximum
+// wait.
+var ErrPoolExhausted = errors.New("connection pool exhausted")
+
+// PoolConfig controls the size of a ConnectionPool and how long its connections live.
+type PoolConfig struct {
+	// MinIdle is the number of idle connections the janitor keeps open, so that bursts don't pay for dialing.
+	MinIdle int
+	// MaxOpen is the maximum number of connections, idle or in use. Connections are opened on demand up to it.
+	MaxOpen int
+	// MaxLifetime is how long a connection is used before it is closed and replaced. Zero means forever.
+	MaxLifetime time.Duration
+	// MaxIdleTime is how long a connection may sit idle before the janitor closes it, as long as MinIdle
+	// connections remain. Zero means forever.
+	MaxIdleTime time.Duration
+	// MaxWait is the longest TakeContext waits for a connection. Zero means no limit.
+	MaxWait time.Duration
+	// JanitorInterval is the time between two runs of the janitor. It defaults to a second.
+	JanitorInterval time.Duration
+
+	// CheckOnTake pings a connection before handing it out.
+	CheckOnTake bool
+	// CheckOnPut pings a connection when it is returned.
+	CheckOnPut bool
+	// HealthCheckInterval makes the janitor ping connections that have been idle without a check for longer.
+	// Zero disables the periodic check.
+	HealthCheckInterval time.Duration
+	// PingTimeout bounds every health check. It defaults to a second.
+	PingTimeout time.Duration
+}
+
+// DefaultPoolConfig is the configuration of pools created with NewConnectionPool.
+var DefaultPoolConfig = PoolConfig{MaxOpen: 10}
+
+type ConnectionPool struct {
+	db  *sql.DB
+	cfg PoolConfig
+	// idle holds the connections ready to be taken.
+	idle chan *pooledConn
+	// slots holds a token for every open connection, idle or in use, so that sending to it blocks once MaxOpen
+	// connections are open and receiving from it frees room for a new one.
+	slots chan struct{}
+	// maxWait is the longest TakeContext waits for a connection, in nanoseconds. Zero means no limit.
+	maxWait atomic.Int64
+
+	mu sync.Mutex
+	// inUse maps the connections handed out to their pool bookkeeping.
+	inUse map[*sql.Conn]*pooledConn
+
+	stopJanitor context.CancelFunc
+	janitorDone chan struct{}
+}
+
+type pooledConn struct {
+	conn      *sql.Conn
+	createdAt time.Time
+	idleSince time.Time
+	// checkedAt is the last time the connection was known to be alive.
+	checkedAt time.Time
+}
+
+// NewConnectionPool creates a pool with DefaultPoolConfig.
+func NewConnectionPool(dsn string) (*ConnectionPool, error) {
+	return NewConnectionPoolWithConfig(dsn, DefaultPoolConfig)
+}
+
+// NewConnectionPoolWithConfig creates a pool and starts its janitor. No connection is opened until one is taken
+// or the janitor tops up MinIdle.
+func NewConnectionPoolWithConfig(dsn string, cfg PoolConfig) (*ConnectionPool, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := newConnectionPool(db, cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// newConnectionPool creates a pool of connections to db and starts its janitor.
+func newConnectionPool(db *sql.DB, cfg PoolConfig) (*ConnectionPool, error) {
+	if cfg.MaxOpen <= 0 || cfg.MinIdle < 0 || cfg.MinIdle > cfg.MaxOpen {
+		return nil, fmt.Errorf("invalid pool config: need 0 <= MinIdle <= MaxOpen and MaxOpen > 0")
+	}
+	if cfg.JanitorInterval <= 0 {
+		cfg.JanitorInterval = time.Second
+	}
+	if cfg.PingTimeout <= 0 {
+		cfg.PingTimeout = time.Second
+	}
+
+	// The pool decides which connections stay open. Without idle connections in database/sql, closing a
+	// *sql.Conn closes the session instead of parking it in a second pool.
+	db.SetMaxIdleConns(0)
+	db.SetMaxOpenConns(cfg.MaxOpen)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &ConnectionPool{
+		db:          db,
+		cfg:         cfg,
+		idle:        make(chan *pooledConn, cfg.MaxOpen),
+		slots:       make(chan struct{}, cfg.MaxOpen),
+		inUse:       map[*sql.Conn]*pooledConn{},
+		stopJanitor: cancel,
+		janitorDone: make(chan struct{}),
+	}
+	c.SetMaxWait(cfg.MaxWait)
+
+	go c.runJanitor(ctx)
+
+	return c, nil
+}
+
+// Take waits for a connection, forever if the pool stays exhausted. Prefer TakeContext.
+func (c *ConnectionPool) Take() *sql.Conn {
+	for {
+		conn, err := c.TakeContext(context.Background())
+		if err == nil {
+			return conn
+		}
+		if errors.Is(err, ErrPoolExhausted) {
+			continue
+		}
+		log.Printf("error opening connection: %v", err)
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// SetMaxWait sets the longest TakeContext waits for a connection before failing with ErrPoolExhausted. Zero or
+// less means no limit.
+func (c *ConnectionPool) SetMaxWait(d time.Duration) {
+	c.maxWait.Store(int64(d))
+}
+
+// TakeContext returns an idle connection, or opens a new one if fewer than MaxOpen are open. Otherwise it waits
+// until a connection is returned or closed, ctx is done or the pool's maximum wait has passed. It returns
+// ctx.Err() if ctx is done first and ErrPoolExhausted if the maximum wait passes first. Idle connections that
+// fail their health check are closed and replaced without the caller noticing.
+func (c *ConnectionPool) TakeContext(ctx context.Context) (*sql.Conn, error) {
+	if maxWait := time.Duration(c.maxWait.Load()); maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, maxWait, ErrPoolExhausted)
+		defer cancel()
+	}
+
+	for {
+		// Prefer idle connections over opening new ones.
+		select {
+		case pc := <-c.idle:
+			if conn, ok := c.checkout(ctx, pc); ok {
+				return conn, nil
+			}
+			continue
+		default:
+		}
+
+		select {
+		case pc := <-c.idle:
+			if conn, ok := c.checkout(ctx, pc); ok {
+				return conn, nil
+			}
+		case c.slots <- struct{}{}:
+			pc, err := c.open(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return c.hand(pc), nil
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+}
+
+// TryTake returns a connection if one is idle or can be opened without waiting for another to be returned. It
+// reports false if the pool is exhausted or opening a connection fails.
+func (c *ConnectionPool) TryTake() (*sql.Conn, bool) {
+	for {
+		// Prefer idle connections over opening new ones.
+		select {
+		case pc := <-c.idle:
+			if conn, ok := c.checkout(context.Background(), pc); ok {
+				return conn, true
+			}
+			continue
+		default:
+		}
+
+		select {
+		case c.slots <- struct{}{}:
+			pc, err := c.open(context.Background())
+			if err != nil {
+				return nil, false
+			}
+			return c.hand(pc), true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// open opens a connection for a slot that has already been taken, giving the slot back on failure.
+func (c *ConnectionPool) open(ctx context.Context) (*pooledConn, error) {
+	conn, err := c.db.Conn(ctx)
+	if err != nil {
+		<-c.slots
+		return nil, fmt.Errorf("error opening connection: %w", err)
+	}
+	now := time.Now()
+	return &pooledConn{conn: conn, createdAt: now, checkedAt: now}, nil
+}
+
+// checkout hands out an idle connection, unless it has outlived MaxLifetime or fails its health check, in which
+// case it is closed.
+func (c *ConnectionPool) checkout(ctx context.Context, pc *pooledConn) (*sql.Conn, bool) {
+	if c.expired(pc, time.Now()) {
+		c.close(pc)
+		return nil, false
+	}
+	if c.cfg.CheckOnTake && !c.ping(ctx, pc) {
+		c.close(pc)
+		return nil, false
+	}
+	return c.hand(pc), true
+}
+
+// hand records a connection as in use and returns it.
+func (c *ConnectionPool) hand(pc *pooledConn) *sql.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.inUse[pc.conn] = pc
+	return pc.conn
+}
+
+// ping checks that the connection is alive. Only PingTimeout bounds the check, not ctx being cancelled: a caller
+// giving up on TakeContext must not get a healthy connection closed.
+func (c *ConnectionPool) ping(ctx context.Context, pc *pooledConn) bool {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.cfg.PingTimeout)
+	defer cancel()
+
+	err := pc.conn.PingContext(ctx)
+	if err != nil {
+		log.Printf("closing connection that failed its health check: %v", err)
+		return false
+	}
+	pc.checkedAt = time.Now()
+	return true
+}
+
+// Put returns a connection taken from the pool. Connections that have outlived MaxLifetime or fail their health
+// check are closed.
+func (c *ConnectionPool) Put(conn *sql.Conn) {
+	c.put(conn, false)
+}
+
+// PutBroken returns a connection the caller found unusable, e.g. because a query failed with a network error.
+// It is closed, and the pool opens a new connection when one is needed.
+func (c *ConnectionPool) PutBroken(conn *sql.Conn) {
+	c.put(conn, true)
+}
+
+func (c *ConnectionPool) put(conn *sql.Conn, broken bool) {
+	c.mu.Lock()
+	pc, ok := c.inUse[conn]
+	delete(c.inUse, conn)
+	c.mu.Unlock()
+	if !ok {
+		log.Printf("connection returned to a pool it wasn't taken from")
+		return
+	}
+
+	now := time.Now()
+	if broken || c.expired(pc, now) || c.cfg.CheckOnPut && !c.ping(context.Background(), pc) {
+		c.close(pc)
+		return
+	}
+	pc.idleSince = now
+	c.idle <- pc
+}
+
+func (c *ConnectionPool) expired(pc *pooledConn, now time.Time) bool {
+	return c.cfg.MaxLifetime > 0 && now.Sub(pc.createdAt) >= c.cfg.MaxLifetime
+}
+
+// close closes a connection and frees its slot.
+func (c *ConnectionPool) close(pc *pooledConn) {
+	err := pc.conn.Close()
+	// database/sql already closes connections whose driver reported them as bad.
+	if err != nil && !errors.Is(err, sql.ErrConnDone) {
+		log.Printf("error closing connection: %v", err)
+	}
+	<-c.slots
+}
+
+// runJanitor closes idle connections that are too old, have been idle too long or fail their periodic health
+// check, and opens connections until MinIdle are idle, every JanitorInterval until ctx is cancelled.
+func (c *ConnectionPool) runJanitor(ctx context.Context) {
+	defer close(c.janitorDone)
+
+	ticker := time.NewTicker(c.cfg.JanitorInterval)
+	defer ticker.Stop()
+
+	for {
+		c.cleanIdle(ctx)
+		c.fillIdle(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// cleanIdle closes idle connections past MaxLifetime or failing their health check, and connections idle for
+// longer than MaxIdleTime as long as MinIdle connections are left.
+func (c *ConnectionPool) cleanIdle(ctx context.Context) {
+	now := time.Now()
+
+	var keep []*pooledConn
+	for range len(c.idle) {
+		var pc *pooledConn
+		select {
+		case pc = <-c.idle:
+		default:
+		}
+		if pc == nil {
+			break
+		}
+
+		idleTooLong := c.cfg.MaxIdleTime > 0 && now.Sub(pc.idleSince) >= c.cfg.MaxIdleTime
+		if c.expired(pc, now) || idleTooLong && len(keep) >= c.cfg.MinIdle {
+			c.close(pc)
+			continue
+		}
+		if c.cfg.HealthCheckInterval > 0 && now.Sub(pc.checkedAt) >= c.cfg.HealthCheckInterval && !c.ping(ctx, pc) {
+			c.close(pc)
+			continue
+		}
+		keep = append(keep, pc)
+	}
+
+	for _, pc := range keep {
+		c.idle <- pc
+	}
+}
+
+// fillIdle opens connections until MinIdle are idle or MaxOpen are open.
+func (c *ConnectionPool) fillIdle(ctx context.Context) {
+	for len(c.idle) < c.cfg.MinIdle {
+		select {
+		case c.slots <- struct{}{}:
+		default:
+			return
+		}
+
+		pc, err := c.open(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("error opening idle connection: %v", err)
+			}
+			return
+		}
+		pc.idleSince = time.Now()
+		c.idle <- pc
+	}
+}
+
+// Stats returns the number of open and idle connections.
+func (c *ConnectionPool) Stats() (open, idle int) {
+	return len(c.slots), len(c.idle)
+}
+
+// Close stops the janitor and closes the idle connections and the database. Connections in use are closed when
+// the database is.
+func (c *ConnectionPool) Close() error {
+	c.stopJanitor()
+	<-c.janitorDone
+
+	for {
+		select {
+		case pc := <-c.idle:
+			c.close(pc)
+		default:
+			return c.db.Close()
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeConnector opens connections that can't run queries but count how many are open and can be killed.
+type fakeConnector struct {
+	opened  atomic.Int64
+	open    atomic.Int64
+	maxOpen atomic.Int64
+	// generation is bumped by kill. Connections opened before fail their pings.
+	generation atomic.Int64
+}
+
+func (f *fakeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	f.opened.Add(1)
+	open := f.open.Add(1)
+	for {
+		peak := f.maxOpen.Load()
+		if open <= peak || f.maxOpen.CompareAndSwap(peak, open) {
+			break
+		}
+	}
+	return &fakeConn{connector: f, generation: f.generation.Load()}, nil
+}
+
+func (f *fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+// kill breaks every connection open so far, as if the database restarted.
+func (f *fakeConnector) kill() {
+	f.generation.Add(1)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return nil, errors.New("use fakeConnector")
+}
+
+type fakeConn struct {
+	connector  *fakeConnector
+	generation int64
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *fakeConn) Close() error {
+	c.connector.open.Add(-1)
+	return nil
+}
+
+func (c *fakeConn) Ping(ctx context.Context) error {
+	if c.generation != c.connector.generation.Load() {
+		return driver.ErrBadConn
+	}
+	return ctx.Err()
+}
+
+func newTestPool(t *testing.T, cfg PoolConfig) (*ConnectionPool, *fakeConnector) {
+	t.Helper()
+
+	connector := &fakeConnector{}
+	pool, err := newConnectionPool(sql.OpenDB(connector), cfg)
+	if err != nil {
+		t.Fatalf("error creating pool: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	return pool, connector
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPoolMaxOpen(t *testing.T) {
+	ctx := context.Background()
+	pool, connector := newTestPool(t, PoolConfig{MaxOpen: 3, MaxWait: 50 * time.Millisecond})
+
+	if open, _ := pool.Stats(); open != 0 {
+		t.Fatalf("expected no connection before the first take, got %d", open)
+	}
+	conn, err := pool.TakeContext(ctx)
+	if err != nil {
+		t.Fatalf("error taking connection: %v", err)
+	}
+	pool.Put(conn)
+	if n := connector.opened.Load(); n != 1 {
+		t.Fatalf("expected one connection opened on demand, got %d", n)
+	}
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			for range 50 {
+				conn, err := pool.TakeContext(ctx)
+				if err != nil {
+					t.Errorf("error taking connection: %v", err)
+					return
+				}
+				time.Sleep(10 * time.Microsecond)
+				pool.Put(conn)
+			}
+		})
+	}
+	wg.Wait()
+
+	if peak := connector.maxOpen.Load(); peak > 3 {
+		t.Fatalf("expected at most 3 connections open, got %d", peak)
+	}
+	if open, _ := pool.Stats(); open > 3 {
+		t.Fatalf("expected at most 3 connections in the pool, got %d", open)
+	}
+}
+
+func TestPoolExhausted(t *testing.T) {
+	ctx := context.Background()
+	pool, _ := newTestPool(t, PoolConfig{MaxOpen: 2, MaxWait: 50 * time.Millisecond})
+
+	for range 2 {
+		_, err := pool.TakeContext(ctx)
+		if err != nil {
+			t.Fatalf("error taking connection: %v", err)
+		}
+	}
+
+	start := time.Now()
+	_, err := pool.TakeContext(ctx)
+	if !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("expected ErrPoolExhausted, got %v", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Fatalf("expected to wait for MaxWait, gave up after %v", waited)
+	}
+
+	if _, ok := pool.TryTake(); ok {
+		t.Fatal("expected TryTake to fail on an exhausted pool")
+	}
+}
+
+func TestPoolTryTakeReusesIdleConnections(t *testing.T) {
+	pool, connector := newTestPool(t, PoolConfig{MaxOpen: 5})
+
+	for range 100 {
+		conn, ok := pool.TryTake()
+		if !ok {
+			t.Fatal("expected TryTake to return a connection")
+		}
+		pool.Put(conn)
+	}
+
+	if n := connector.opened.Load(); n != 1 {
+		t.Fatalf("expected the idle connection to be reused, got %d connections opened", n)
+	}
+}
+
+func TestPoolJanitorKeepsMinIdle(t *testing.T) {
+	ctx := context.Background()
+	pool, connector := newTestPool(t, PoolConfig{
+		MinIdle:         2,
+		MaxOpen:         5,
+		MaxIdleTime:     10 * time.Millisecond,
+		JanitorInterval: 5 * time.Millisecond,
+	})
+
+	waitFor(t, "the janitor to open MinIdle connections", func() bool {
+		_, idle := pool.Stats()
+		return idle == 2
+	})
+
+	conns := make([]*sql.Conn, 5)
+	for i := range conns {
+		conn, err := pool.TakeContext(ctx)
+		if err != nil {
+			t.Fatalf("error taking connection: %v", err)
+		}
+		conns[i] = conn
+	}
+	for _, conn := range conns {
+		pool.Put(conn)
+	}
+
+	waitFor(t, "the janitor to close connections idle for too long", func() bool {
+		open, _ := pool.Stats()
+		return open == 2
+	})
+	time.Sleep(50 * time.Millisecond)
+	if open, idle := pool.Stats(); open != 2 || idle != 2 {
+		t.Fatalf("expected the janitor to keep 2 idle connections, got %d open and %d idle", open, idle)
+	}
+	if n := connector.open.Load(); n != 2 {
+		t.Fatalf("expected 2 connections left open in the driver, got %d", n)
+	}
+}
+
+func TestPoolReplacesBrokenConnection(t *testing.T) {
+	ctx := context.Background()
+	pool, connector := newTestPool(t, PoolConfig{MaxOpen: 1, CheckOnTake: true})
+
+	conn, err := pool.TakeContext(ctx)
+	if err != nil {
+		t.Fatalf("error taking connection: %v", err)
+	}
+	pool.Put(conn)
+
+	connector.kill()
+
+	conn, err = pool.TakeContext(ctx)
+	if err != nil {
+		t.Fatalf("error taking connection after the old one broke: %v", err)
+	}
+	if err := conn.PingContext(ctx); err != nil {
+		t.Fatalf("expected a working connection, got %v", err)
+	}
+	if n := connector.opened.Load(); n != 2 {
+		t.Fatalf("expected the broken connection to be replaced, got %d connections opened", n)
+	}
+	if open, _ := pool.Stats(); open != 1 {
+		t.Fatalf("expected the broken connection's slot to be freed, got %d open", open)
+	}
+}
+
+func TestPoolKeepsHealthyConnectionOnCancel(t *testing.T) {
+	pool, connector := newTestPool(t, PoolConfig{MaxOpen: 1, CheckOnTake: true})
+
+	conn, err := pool.TakeContext(context.Background())
+	if err != nil {
+		t.Fatalf("error taking connection: %v", err)
+	}
+	pool.Put(conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	conn, err = pool.TakeContext(ctx)
+	if err == nil {
+		pool.Put(conn)
+	}
+
+	if n := connector.opened.Load(); n != 1 {
+		t.Fatalf("expected the healthy connection to be kept, got %d connections opened", n)
+	}
+	if open, idle := pool.Stats(); open != 1 || idle != 1 {
+		t.Fatalf("expected 1 open and idle connection, got %d open and %d idle", open, idle)
+	}
+}
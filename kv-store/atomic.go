@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	invalidTextRepresentation pq.ErrorCode = "22P02"
+	numericValueOutOfRange    pq.ErrorCode = "22003"
+)
+
+// ErrNotInteger is returned by Increment when the stored value is not a 64-bit integer.
+var ErrNotInteger = errors.New("value is not an integer or out of range")
+
+// PutIfAbsent stores the value only if key doesn't exist or has expired. It reports whether the value was stored.
+func (k *KVStore) PutIfAbsent(key string, value string, expiration time.Duration) (bool, error) {
+	return k.PutIfAbsentContext(context.Background(), key, value, expiration)
+}
+
+func (k *KVStore) PutIfAbsentContext(ctx context.Context, key string, value string, expiration time.Duration) (bool, error) {
+	err := k.checkValueSize(key, len(value))
+	if err != nil {
+		return false, err
+	}
+	expiresAt := expiryTime(expiration)
+
+	res, err := k.db.ExecContext(ctx, `
+	INSERT INTO kv (key, value, expires_at) VALUES ($1, $2, $3)
+	ON CONFLICT (key) DO UPDATE SET value = $2, expires_at = $3 WHERE kv.expires_at <= NOW()
+	`, key, []byte(value), expiresAt)
+	k.invalidate(key)
+	if err != nil {
+		return false, fmt.Errorf("error inserting kv: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return n == 1, nil
+}
+
+// PutIfPresent stores the value only if key exists and hasn't expired. It reports whether the value was stored.
+func (k *KVStore) PutIfPresent(key string, value string, expiration time.Duration) (bool, error) {
+	return k.PutIfPresentContext(context.Background(), key, value, expiration)
+}
+
+func (k *KVStore) PutIfPresentContext(ctx context.Context, key string, value string, expiration time.Duration) (bool, error) {
+	err := k.checkValueSize(key, len(value))
+	if err != nil {
+		return false, err
+	}
+
+	res, err := k.db.ExecContext(ctx, `
+	UPDATE kv SET value = $2, expires_at = $3 WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())
+	`, key, []byte(value), expiryTime(expiration))
+	k.invalidate(key)
+	if err != nil {
+		return false, fmt.Errorf("error updating kv: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return n == 1, nil
+}
+
+// CompareAndSwap replaces the value of key with newValue only if its current value is oldValue. The expiration
+// of the key is left unchanged. It reports whether the value was replaced.
+func (k *KVStore) CompareAndSwap(key string, oldValue, newValue string) (bool, error) {
+	return k.CompareAndSwapContext(context.Background(), key, oldValue, newValue)
+}
+
+func (k *KVStore) CompareAndSwapContext(ctx context.Context, key string, oldValue, newValue string) (bool, error) {
+	err := k.checkValueSize(key, len(newValue))
+	if err != nil {
+		return false, err
+	}
+
+	res, err := k.db.ExecContext(ctx, `
+	UPDATE kv SET value = $3 WHERE key = $1 AND value = $2 AND (expires_at IS NULL OR expires_at > NOW())
+	`, key, []byte(oldValue), []byte(newValue))
+	k.invalidate(key)
+	if err != nil {
+		return false, fmt.Errorf("error updating kv: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return n == 1, nil
+}
+
+// Increment atomically adds delta to the integer stored under key and returns the new value. The expiration of
+// the key is left unchanged. It returns ErrNotFound if the key doesn't exist, so counters have to be created
+// first, e.g. with PutIfAbsent, and ErrNotInteger if the value is not an integer.
+func (k *KVStore) Increment(key string, delta int64) (int64, error) {
+	return k.IncrementContext(context.Background(), key, delta)
+}
+
+func (k *KVStore) IncrementContext(ctx context.Context, key string, delta int64) (int64, error) {
+	var value int64
+
+	err := k.db.QueryRowContext(ctx, `
+	UPDATE kv SET value = CONVERT_TO((CONVERT_FROM(value, 'UTF8')::BIGINT + $2)::TEXT, 'UTF8')
+	WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())
+	RETURNING CONVERT_FROM(value, 'UTF8')::BIGINT
+	`, key, delta).Scan(&value)
+	k.invalidate(key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("error incrementing %q: %w", key, ErrNotFound)
+		}
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && (pqErr.Code == invalidTextRepresentation || pqErr.Code == numericValueOutOfRange) {
+			return 0, fmt.Errorf("error incrementing %q: %w", key, ErrNotInteger)
+		}
+		return 0, fmt.Errorf("error incrementing kv: %w", err)
+	}
+	return value, nil
+}
+
+// Swap stores the value under key like Put and returns the value it replaced. found is false if the key didn't
+// exist or had expired.
+func (k *KVStore) Swap(key string, value string, expiration time.Duration) (previous string, found bool, err error) {
+	return k.SwapContext(context.Background(), key, value, expiration)
+}
+
+func (k *KVStore) SwapContext(ctx context.Context, key string, value string, expiration time.Duration) (previous string, found bool, err error) {
+	err = k.checkValueSize(key, len(value))
+	if err != nil {
+		return "", false, err
+	}
+	expiresAt := expiryTime(expiration)
+
+	// A missing key can't be locked, so a concurrent insert can win the race between the SELECT and the INSERT.
+	// The INSERT then does nothing and the swap starts over, this time finding and locking the new row.
+	for {
+		previous, found, done, err := k.trySwap(ctx, key, value, expiresAt)
+		k.invalidate(key)
+		if err != nil || done {
+			return previous, found, err
+		}
+	}
+}
+
+// trySwap runs a single swap in a transaction. done is false if the key was inserted concurrently.
+func (k *KVStore) trySwap(ctx context.Context, key string, value string, expiresAt sql.NullTime) (previous string, found, done bool, err error) {
+	tx, err := k.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", false, false, fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var live bool
+	err = tx.QueryRowContext(ctx, `
+	SELECT value, COALESCE(expires_at > NOW(), TRUE) FROM kv WHERE key = $1 FOR UPDATE
+	`, key).Scan(&previous, &live)
+	switch {
+	case err == sql.ErrNoRows:
+		res, err := tx.ExecContext(ctx, `
+		INSERT INTO kv (key, value, expires_at) VALUES ($1, $2, $3) ON CONFLICT (key) DO NOTHING
+		`, key, []byte(value), expiresAt)
+		if err != nil {
+			return "", false, false, fmt.Errorf("error inserting kv: %w", err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return "", false, false, fmt.Errorf("error getting rows affected: %w", err)
+		}
+		if n == 0 {
+			return "", false, false, nil
+		}
+	case err != nil:
+		return "", false, false, fmt.Errorf("error scanning value: %w", err)
+	default:
+		_, err = tx.ExecContext(ctx, `
+		UPDATE kv SET value = $2, expires_at = $3 WHERE key = $1
+		`, key, []byte(value), expiresAt)
+		if err != nil {
+			return "", false, false, fmt.Errorf("error updating kv: %w", err)
+		}
+		if !live {
+			previous = ""
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", false, false, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return previous, live, true, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MGetResult is the outcome of looking up a single key in MGet.
+type MGetResult struct {
+	Key   string
+	Value string
+	// Found is false if the key doesn't exist or has expired.
+	Found bool
+}
+
+// MGet looks up every key with a single query. It returns one result per key, in the order of keys.
+func (k *KVStore) MGet(keys []string) ([]MGetResult, error) {
+	return k.MGetContext(context.Background(), keys)
+}
+
+func (k *KVStore) MGetContext(ctx context.Context, keys []string) ([]MGetResult, error) {
+	rows, err := k.db.QueryContext(ctx, `
+	SELECT key, value FROM kv WHERE key = ANY($1) AND (expires_at IS NULL OR expires_at > NOW())
+	`, pq.Array(keys))
+	if err != nil {
+		return nil, fmt.Errorf("error querying kv: %w", err)
+	}
+	defer rows.Close()
+
+	values := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		err = rows.Scan(&key, &value)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning kv: %w", err)
+		}
+		values[key] = value
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying kv: %w", err)
+	}
+
+	results := make([]MGetResult, len(keys))
+	for i, key := range keys {
+		value, ok := values[key]
+		results[i] = MGetResult{Key: key, Value: value, Found: ok}
+	}
+	return results, nil
+}
+
+// MPut stores every key-value pair with the same expiration in a single statement.
+func (k *KVStore) MPut(items map[string]string, expiration time.Duration) error {
+	return k.MPutContext(context.Background(), items, expiration)
+}
+
+func (k *KVStore) MPutContext(ctx context.Context, items map[string]string, expiration time.Duration) error {
+	if len(items) == 0 {
+		return nil
+	}
+	expiresAt := expiryTime(expiration)
+
+	keys := make([]string, 0, len(items))
+	values := make([][]byte, 0, len(items))
+	for key, value := range items {
+		err := k.checkValueSize(key, len(value))
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		values = append(values, []byte(value))
+	}
+
+	_, err := k.db.ExecContext(ctx, `
+	INSERT INTO kv (key, value, expires_at) SELECT key, value, $3 FROM UNNEST($1::TEXT[], $2::BYTEA[]) AS t (key, value)
+	ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at
+	`, pq.Array(keys), pq.ByteaArray(values), expiresAt)
+	k.invalidate(keys...)
+	if err != nil {
+		return fmt.Errorf("error inserting kv: %w", err)
+	}
+
+	return nil
+}
+
+// MDel removes every key in a single statement and returns the number of keys that existed.
+func (k *KVStore) MDel(keys []string) (int, error) {
+	return k.MDelContext(context.Background(), keys)
+}
+
+func (k *KVStore) MDelContext(ctx context.Context, keys []string) (int, error) {
+	res, err := k.db.ExecContext(ctx, `
+	DELETE FROM kv WHERE key = ANY($1)
+	`, pq.Array(keys))
+	k.invalidate(keys...)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting kv: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return int(n), nil
+}
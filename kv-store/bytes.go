@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultMaxValueSize is the value size limit of stores created with NewKVStore.
+const DefaultMaxValueSize = 1 << 20
+
+// ErrValueTooLarge is returned when writing a value larger than the store's MaxValueSize.
+var ErrValueTooLarge = errors.New("value too large")
+
+// checkValueSize returns an error wrapping ErrValueTooLarge if a value of the given size can't be stored under key.
+func (k *KVStore) checkValueSize(key string, size int) error {
+	if size > k.maxValueSize {
+		return fmt.Errorf("value of %q is %d bytes, limit is %d: %w", key, size, k.maxValueSize, ErrValueTooLarge)
+	}
+	return nil
+}
+
+// PutBytes stores a binary value under key for the given duration, or forever if expiration is NoExpiration.
+func (k *KVStore) PutBytes(key string, value []byte, expiration time.Duration) error {
+	return k.PutBytesContext(context.Background(), key, value, expiration)
+}
+
+func (k *KVStore) PutBytesContext(ctx context.Context, key string, value []byte, expiration time.Duration) error {
+	err := k.checkValueSize(key, len(value))
+	if err != nil {
+		return err
+	}
+	expiresAt := expiryTime(expiration)
+
+	_, err = k.db.ExecContext(ctx, `
+	INSERT INTO kv (key, value, expires_at) VALUES ($1, $2, $3) 
+	ON CONFLICT (key) DO UPDATE SET value = $2, expires_at = $3
+	`, key, value, expiresAt)
+	k.invalidate(key)
+	if err != nil {
+		return fmt.Errorf("error inserting kv: %w", err)
+	}
+
+	return nil
+}
+
+// GetBytes returns the binary value stored under key, from the cache if it is enabled. It returns ErrNotFound if
+// the key doesn't exist and ErrExpired if it has expired but hasn't been swept yet.
+func (k *KVStore) GetBytes(key string) ([]byte, error) {
+	return k.GetBytesContext(context.Background(), key)
+}
+
+func (k *KVStore) GetBytesContext(ctx context.Context, key string) ([]byte, error) {
+	cache := k.cache.Load()
+	var generation uint64
+	if cache != nil {
+		if value, ok := cache.get(key); ok {
+			return value, nil
+		}
+		generation = cache.currentGeneration()
+	}
+
+	var value []byte
+	var expiresAt sql.NullTime
+	var expired bool
+
+	err := k.db.QueryRowContext(ctx, `
+	SELECT value, expires_at, COALESCE(expires_at <= NOW(), FALSE) FROM kv WHERE key = $1
+	`, key).Scan(&value, &expiresAt, &expired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("error getting %q: %w", key, ErrNotFound)
+		}
+		return nil, fmt.Errorf("error scanning value: %w", err)
+	}
+	if expired {
+		return nil, fmt.Errorf("error getting %q: %w", key, ErrExpired)
+	}
+
+	if cache != nil {
+		cache.add(key, value, expiresAt.Time, generation)
+	}
+	return value, nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// CacheStats counts the lookups served by the cache.
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	// Size is the number of entries currently cached.
+	Size int
+}
+
+// lruCache is a bounded least-recently-used cache of values that remembers when each value expires.
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	// order holds the entries, most recently used first.
+	order *list.List
+	// generation is bumped on every invalidation, so that a value read from the database before an invalidation
+	// isn't cached after it.
+	generation uint64
+	stats      CacheStats
+}
+
+type cacheEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// get returns a copy of the cached value of key unless it is missing or has expired. Callers own the copy.
+func (c *lruCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if !entry.expiresAt.IsZero() && !time.Now().Before(entry.expiresAt) {
+		c.removeElement(elem)
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	c.stats.Hits++
+	return bytes.Clone(entry.value), true
+}
+
+// currentGeneration returns the generation to pass to add for a value about to be read from the database.
+func (c *lruCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// add caches a copy of a value read at the given generation, unless something was invalidated since. A zero
+// expiresAt means the value never expires.
+func (c *lruCache) add(key string, value []byte, expiresAt time.Time, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	value = bytes.Clone(value)
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &cacheEntry{key: key, value: value, expiresAt: expiresAt}
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, value: value, expiresAt: expiresAt})
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// invalidate removes the given keys from the cache.
+func (c *lruCache) invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, key := range keys {
+		if elem, ok := c.entries[key]; ok {
+			c.removeElement(elem)
+		}
+	}
+}
+
+// purge removes every entry from the cache.
+func (c *lruCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	clear(c.entries)
+	c.order.Init()
+}
+
+// removeElement removes an entry from the cache. c.mu must be held.
+func (c *lruCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
+
+// EnableCache puts a read-through LRU cache of up to size entries in front of Get and GetBytes. Writes made
+// through this store invalidate the cache right away. Writes made by other processes invalidate it as soon as
+// their notification arrives on a listener connection, so other processes' writes can be read stale for the time
+// it takes the notification to arrive. The listener runs until ctx is cancelled or the store is closed.
+func (k *KVStore) EnableCache(ctx context.Context, size int) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid cache size %d: must be positive", size)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.stopCache != nil {
+		return errors.New("cache is already enabled")
+	}
+
+	listener, err := k.listenForChanges("cache invalidation")
+	if err != nil {
+		return err
+	}
+
+	cache := newLRUCache(size)
+	k.cache.Store(cache)
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	k.stopCache = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+		defer listener.Close()
+		// Without the listener, the cache would serve stale values forever.
+		defer k.cache.Store(nil)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				// A nil notification means the connection was re-established and notifications may have been
+				// lost in between.
+				if n == nil {
+					cache.purge()
+					continue
+				}
+				change, err := parseChange(n.Extra)
+				if err != nil {
+					log.Printf("error parsing change notification: %v", err)
+					cache.purge()
+					continue
+				}
+				cache.invalidate(change.Key)
+			case <-time.After(time.Minute):
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// CacheStats returns the hit and miss counters of the cache, or zero stats if the cache is not enabled.
+func (k *KVStore) CacheStats() CacheStats {
+	cache := k.cache.Load()
+	if cache == nil {
+		return CacheStats{}
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	stats := cache.stats
+	stats.Size = cache.order.Len()
+	return stats
+}
+
+// invalidate removes keys written by this store from the cache, if it is enabled.
+func (k *KVStore) invalidate(keys ...string) {
+	if cache := k.cache.Load(); cache != nil {
+		cache.invalidate(keys...)
+	}
+}
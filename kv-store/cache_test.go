@@ -0,0 +1,22 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUCacheCopiesValues(t *testing.T) {
+	c := newLRUCache(1)
+
+	value := []byte("value")
+	c.add("key", value, time.Time{}, c.currentGeneration())
+	value[0] = 'X'
+
+	cached, _ := c.get("key")
+	cached[1] = 'X'
+
+	cached, ok := c.get("key")
+	if !ok || string(cached) != "value" {
+		t.Fatalf("expected cached value to be unaffected by callers, got %q, %v", cached, ok)
+	}
+}
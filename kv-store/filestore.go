@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	opPut = "put"
+	opDel = "del"
+
+	// compactMinRecords is the log size below which the log is never compacted.
+	compactMinRecords = 1000
+)
+
+// FileStore keeps keys in memory and makes every write durable by appending it to a write-ahead log and syncing
+// the file before applying it. Opening the store replays the log. Once the log holds more than twice as many
+// records as there are live keys, it is compacted into one record per live key.
+type FileStore struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	mem  *MemoryStore
+	// records is the number of records in the log.
+	records int
+}
+
+// logRecord is a line of the log.
+type logRecord struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+	// ExpiresAt is the expiry time in Unix nanoseconds, 0 for keys that never expire.
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// OpenFileStore opens the store logged at path, creating the file if it doesn't exist. A record cut short by a
+// crash at the end of the log is discarded.
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, mem: NewMemoryStore()}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening log: %w", err)
+	}
+
+	size, err := s.replay(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Drop a torn record at the end so that new records are appended after the last complete one.
+	s.file = file
+	err = s.truncate(size)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// replay applies every complete record of the log and returns the size of the log up to the last one.
+func (s *FileStore) replay(file *os.File) (int64, error) {
+	r := bufio.NewReader(file)
+	var size int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A line without a newline is a record whose write didn't finish.
+			return size, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error reading log: %w", err)
+		}
+
+		var record logRecord
+		err = json.Unmarshal(bytes.TrimSuffix(line, []byte("\n")), &record)
+		if err != nil {
+			if _, peekErr := r.Peek(1); peekErr == io.EOF {
+				return size, nil
+			}
+			return 0, fmt.Errorf("error decoding log record at offset %d: %w", size, err)
+		}
+
+		s.apply(record)
+		s.records++
+		size += int64(len(line))
+	}
+}
+
+func (s *FileStore) apply(record logRecord) {
+	switch record.Op {
+	case opPut:
+		entry := memoryEntry{value: string(record.Value)}
+		if record.ExpiresAt != 0 {
+			entry.expiresAt = time.Unix(0, record.ExpiresAt)
+		}
+		s.mem.put(record.Key, entry)
+	case opDel:
+		s.mem.del(record.Key)
+	}
+}
+
+// write appends records to the log, syncs it and applies them. s.mu must be held.
+func (s *FileStore) write(records ...logRecord) error {
+	if s.file == nil {
+		return errors.New("file store is closed")
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, record := range records {
+		err := enc.Encode(record)
+		if err != nil {
+			return fmt.Errorf("error encoding log record: %w", err)
+		}
+	}
+
+	offset, err := s.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("error getting log offset: %w", err)
+	}
+
+	_, err = s.file.Write(buf.Bytes())
+	if err != nil {
+		return errors.Join(fmt.Errorf("error appending to log: %w", err), s.truncate(offset))
+	}
+	err = s.file.Sync()
+	if err != nil {
+		return errors.Join(fmt.Errorf("error syncing log: %w", err), s.truncate(offset))
+	}
+
+	for _, record := range records {
+		s.apply(record)
+	}
+	s.records += len(records)
+
+	// The records are durable at this point, so a failed compaction doesn't fail the write. The log just stays
+	// longer until the next attempt.
+	if s.records > compactMinRecords && s.records > 2*s.mem.count() {
+		err = s.compact()
+		if err != nil {
+			log.Printf("error compacting %s: %v", s.path, err)
+		}
+	}
+	return nil
+}
+
+// truncate cuts the log at offset and moves the write position there, so that later records are not written
+// after a torn one.
+func (s *FileStore) truncate(offset int64) error {
+	err := s.file.Truncate(offset)
+	if err != nil {
+		return fmt.Errorf("error truncating log: %w", err)
+	}
+	_, err = s.file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return fmt.Errorf("error seeking log: %w", err)
+	}
+	return nil
+}
+
+func (s *FileStore) PutContext(ctx context.Context, key string, value string, expiration time.Duration) error {
+	record := logRecord{Op: opPut, Key: key, Value: []byte(value)}
+	if expiresAt := expiryDeadline(expiration); !expiresAt.IsZero() {
+		record.ExpiresAt = expiresAt.UnixNano()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.write(record)
+}
+
+func (s *FileStore) GetContext(ctx context.Context, key string) (string, error) {
+	return s.mem.GetContext(ctx, key)
+}
+
+func (s *FileStore) DelContext(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Deleting a missing key doesn't need a record.
+	if _, err := s.mem.get(key); err != nil {
+		return nil
+	}
+	return s.write(logRecord{Op: opDel, Key: key})
+}
+
+func (s *FileStore) TTLContext(ctx context.Context, key string) (time.Duration, error) {
+	return s.mem.TTLContext(ctx, key)
+}
+
+// Compact rewrites the log with a single record per live key.
+func (s *FileStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return errors.New("file store is closed")
+	}
+	return s.compact()
+}
+
+// compact writes the live keys to a new log and swaps it in atomically. s.mu must be held.
+func (s *FileStore) compact() error {
+	tmpPath := s.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("error creating compacted log: %w", err)
+	}
+
+	live := s.mem.live()
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for key, entry := range live {
+		record := logRecord{Op: opPut, Key: key, Value: []byte(entry.value)}
+		if !entry.expiresAt.IsZero() {
+			record.ExpiresAt = entry.expiresAt.UnixNano()
+		}
+		err = enc.Encode(record)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("error writing compacted log: %w", err)
+		}
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing compacted log: %w", err)
+	}
+
+	err = os.Rename(tmpPath, s.path)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("error replacing log: %w", err)
+	}
+
+	// The old log has no name anymore, so switch to the new one even if the rename might not survive a crash.
+	// Records appended to the old log would be lost on the next open.
+	s.file.Close()
+	s.file = tmp
+	s.records = len(live)
+
+	return syncDir(filepath.Dir(s.path))
+}
+
+// syncDir makes a rename in dir durable. It is a variable so that tests can make it fail.
+var syncDir = func(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("error opening directory: %w", err)
+	}
+	defer d.Close()
+
+	err = d.Sync()
+	if err != nil {
+		return fmt.Errorf("error syncing directory: %w", err)
+	}
+	return nil
+}
+
+// Close closes the log. The store can't be used afterwards.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrLockNotHeld is returned when releasing a lock whose lease has expired or was never acquired.
+var ErrLockNotHeld = errors.New("lock not held")
+
+// minLockTTL is the shortest lease a lock accepts. The lease is renewed every third of it, and each renewal is a
+// database round trip that must finish before the lease runs out. Shorter leases are lost to a single slow query
+// or GC pause.
+const minLockTTL = time.Second
+
+// Lock is a named lease stored in the `kv` table under "lock:<name>". The holder's owner token is the value of
+// the key, so only the holder can renew or release it, and the lease expires with the key if the holder dies.
+// Every acquisition also increments a persistent counter under "fence:<name>", the fencing token, which
+// resources guarded by the lock can use to reject writes from a holder whose lease has already expired.
+type Lock struct {
+	store *KVStore
+	name  string
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	token string
+	fence int64
+	// stopRenew stops the renewal goroutine and waits for it to exit. It is nil while the lock isn't held.
+	stopRenew func()
+	lost      chan struct{}
+}
+
+// NewLock returns a lock named name whose lease lasts ttl unless renewed. Acquiring it fails if ttl is shorter
+// than a second, including NoExpiration.
+func (k *KVStore) NewLock(name string, ttl time.Duration) *Lock {
+	return &Lock{store: k, name: name, ttl: ttl}
+}
+
+func (l *Lock) key() string {
+	return "lock:" + l.name
+}
+
+func (l *Lock) fenceKey() string {
+	return "fence:" + l.name
+}
+
+// TryAcquire acquires the lock if no one holds it and starts renewing the lease in the background. It reports
+// whether the lock was acquired.
+func (l *Lock) TryAcquire(ctx context.Context) (bool, error) {
+	if l.ttl < minLockTTL {
+		return false, fmt.Errorf("invalid lock ttl %v: must be at least %v", l.ttl, minLockTTL)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopRenew != nil {
+		return false, errors.New("lock is already held by this caller")
+	}
+
+	token, err := newOwnerToken()
+	if err != nil {
+		return false, err
+	}
+
+	// The lease expires ttl after the request is built, so it is valid for at least ttl from now.
+	leaseStart := time.Now()
+	fence, acquired, err := l.acquire(ctx, token)
+	if err != nil || !acquired {
+		return false, err
+	}
+
+	l.token, l.fence = token, fence
+	l.lost = make(chan struct{})
+	l.startRenewing(token, leaseStart, l.lost)
+	return true, nil
+}
+
+// Acquire waits until the lock is acquired, trying again every retryInterval, or ctx is done.
+func (l *Lock) Acquire(ctx context.Context, retryInterval time.Duration) error {
+	for {
+		acquired, err := l.TryAcquire(ctx)
+		if err != nil || acquired {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// acquire takes the lease with the owner token if it is free and increments the fencing token in the same
+// transaction. Competing acquisitions wait on the lease row until the transaction commits, so fencing tokens
+// are handed out in the order the lease is acquired.
+func (l *Lock) acquire(ctx context.Context, token string) (fence int64, acquired bool, err error) {
+	tx, err := l.store.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+	INSERT INTO kv (key, value, expires_at) VALUES ($1, $2, $3)
+	ON CONFLICT (key) DO UPDATE SET value = $2, expires_at = $3 WHERE kv.expires_at <= NOW()
+	`, l.key(), []byte(token), expiryTime(l.ttl))
+	if err != nil {
+		return 0, false, fmt.Errorf("error acquiring lease: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if n == 0 {
+		return 0, false, nil
+	}
+
+	var value []byte
+	err = tx.QueryRowContext(ctx, `
+	INSERT INTO kv (key, value, expires_at) VALUES ($1, '1', NULL)
+	ON CONFLICT (key) DO UPDATE SET value = CONVERT_TO((CONVERT_FROM(kv.value, 'UTF8')::BIGINT + 1)::TEXT, 'UTF8')
+	RETURNING value
+	`, l.fenceKey()).Scan(&value)
+	if err != nil {
+		return 0, false, fmt.Errorf("error incrementing fencing token: %w", err)
+	}
+	fence, err = strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("error parsing fencing token: %w", err)
+	}
+
+	err = tx.Commit()
+	l.store.invalidate(l.key(), l.fenceKey())
+	if err != nil {
+		return 0, false, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return fence, true, nil
+}
+
+// startRenewing extends the lease every third of its TTL until the lock is released. It closes lost and stops
+// once the lease has been taken over, or once renewals have failed, e.g. because the database is unreachable,
+// until a TTL has passed since the lease last started. leaseStart is when the current lease was requested.
+// l.mu must be held.
+func (l *Lock) startRenewing(token string, leaseStart time.Time, lost chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	l.stopRenew = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// A renewal that can't finish before the lease runs out is no use.
+			renewStart := time.Now()
+			renewCtx, cancelRenew := context.WithDeadline(ctx, leaseStart.Add(l.ttl))
+			held, err := l.renew(renewCtx, token)
+			cancelRenew()
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("error renewing lock %s: %v", l.name, err)
+				if time.Since(leaseStart) < l.ttl {
+					// The lease is still valid. Try again on the next tick.
+					continue
+				}
+				held = false
+			}
+			if !held {
+				close(lost)
+				return
+			}
+			leaseStart = renewStart
+		}
+	}()
+}
+
+// renew extends the lease if it is still held with the owner token.
+func (l *Lock) renew(ctx context.Context, token string) (bool, error) {
+	res, err := l.store.db.ExecContext(ctx, `
+	UPDATE kv SET expires_at = $3 WHERE key = $1 AND value = $2 AND expires_at > NOW()
+	`, l.key(), []byte(token), expiryTime(l.ttl))
+	l.store.invalidate(l.key())
+	if err != nil {
+		return false, fmt.Errorf("error renewing lease: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return n == 1, nil
+}
+
+// Release stops renewing the lease and deletes it if it is still held with this lock's owner token. It returns
+// ErrLockNotHeld if the lease was lost in the meantime.
+func (l *Lock) Release(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopRenew == nil {
+		return ErrLockNotHeld
+	}
+	l.stopRenew()
+	l.stopRenew, l.lost = nil, nil
+
+	res, err := l.store.db.ExecContext(ctx, `
+	DELETE FROM kv WHERE key = $1 AND value = $2 AND expires_at > NOW()
+	`, l.key(), []byte(l.token))
+	l.store.invalidate(l.key())
+	if err != nil {
+		return fmt.Errorf("error releasing lease: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// FencingToken returns the fencing token of the current acquisition. It is only meaningful while the lock is
+// held.
+func (l *Lock) FencingToken() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.fence
+}
+
+// Lost returns a channel that is closed if the lease of the current acquisition is lost before it is released,
+// because someone else took it over or because renewals failed for longer than the TTL. The lock has to be released before it can be acquired
+// again. It returns nil while the lock isn't held.
+func (l *Lock) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.lost
+}
+
+// newOwnerToken returns a random token identifying a single acquisition of a lock.
+func newOwnerToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("error generating owner token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps keys in a map. Expired keys are removed when they are next read.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	value string
+	// expiresAt is zero for keys that never expire.
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]memoryEntry{}}
+}
+
+// expiryDeadline returns the time a key stored now with the given expiration expires, zero if it never does.
+func expiryDeadline(expiration time.Duration) time.Time {
+	if expiration == NoExpiration {
+		return time.Time{}
+	}
+	return time.Now().Add(max(expiration, 0))
+}
+
+func (s *MemoryStore) PutContext(ctx context.Context, key string, value string, expiration time.Duration) error {
+	s.put(key, memoryEntry{value: value, expiresAt: expiryDeadline(expiration)})
+	return nil
+}
+
+func (s *MemoryStore) put(key string, entry memoryEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = entry
+}
+
+func (s *MemoryStore) GetContext(ctx context.Context, key string) (string, error) {
+	entry, err := s.get(key)
+	if err != nil {
+		return "", err
+	}
+	return entry.value, nil
+}
+
+// get returns the live entry of key, removing it if it has expired.
+func (s *MemoryStore) get(key string) (memoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return memoryEntry{}, fmt.Errorf("error getting %q: %w", key, ErrNotFound)
+	}
+	if entry.expired(time.Now()) {
+		delete(s.entries, key)
+		return memoryEntry{}, fmt.Errorf("error getting %q: %w", key, ErrExpired)
+	}
+	return entry, nil
+}
+
+func (s *MemoryStore) DelContext(ctx context.Context, key string) error {
+	s.del(key)
+	return nil
+}
+
+func (s *MemoryStore) del(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+}
+
+func (s *MemoryStore) TTLContext(ctx context.Context, key string) (time.Duration, error) {
+	entry, err := s.get(key)
+	if err != nil {
+		return 0, err
+	}
+	if entry.expiresAt.IsZero() {
+		return NoExpiration, nil
+	}
+	return time.Until(entry.expiresAt), nil
+}
+
+// count returns the number of keys, including expired keys that haven't been removed yet.
+func (s *MemoryStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+// live returns a copy of every entry that hasn't expired.
+func (s *MemoryStore) live() map[string]memoryEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	live := make(map[string]memoryEntry, len(s.entries))
+	for key, entry := range s.entries {
+		if !entry.expired(now) {
+			live[key] = entry
+		}
+	}
+	return live
+}
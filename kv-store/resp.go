@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxBulkLength bounds the size of a single argument a client can send.
+const maxBulkLength = 512 << 20
+
+// maxArrayLength bounds the number of arguments of a command, like Redis does.
+const maxArrayLength = 1024 * 1024
+
+// readChunkSize is how much of a bulk string, and how many arguments, are allocated before more data arrives, so
+// that a short header announcing a huge command doesn't make the server allocate it up front.
+const readChunkSize = 64 << 10
+
+// errProtocol is returned for requests that don't follow RESP. The connection can't be used afterwards.
+var errProtocol = errors.New("protocol error")
+
+// readCommand reads a command sent as an array of bulk strings, or as an inline command such as "GET key\r\n".
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		var args [][]byte
+		for field := range strings.FieldsSeq(line) {
+			args = append(args, []byte(field))
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxArrayLength {
+		return nil, fmt.Errorf("%w: invalid array length %q", errProtocol, line[1:])
+	}
+
+	args := make([][]byte, 0, min(n, readChunkSize))
+	for range n {
+		line, err = readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected bulk string, got %q", errProtocol, line)
+		}
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 || length > maxBulkLength {
+			return nil, fmt.Errorf("%w: invalid bulk length %q", errProtocol, line[1:])
+		}
+
+		arg, err := readBulk(r, length)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads a bulk string of the given length and the CRLF following it. The buffer grows by at most
+// readChunkSize at a time, as the data arrives.
+func readBulk(r *bufio.Reader, length int) ([]byte, error) {
+	total := length + 2
+	buf := make([]byte, 0, min(total, readChunkSize))
+	for len(buf) < total {
+		n := min(total-len(buf), readChunkSize)
+		buf = slices.Grow(buf, n)
+		_, err := io.ReadFull(r, buf[len(buf):len(buf)+n])
+		if err != nil {
+			return nil, err
+		}
+		buf = buf[:len(buf)+n]
+	}
+	return buf[:length], nil
+}
+
+// readLine reads a line terminated by CRLF, or by LF for inline commands typed by hand.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// respWriter writes RESP replies to a buffered connection.
+type respWriter struct {
+	w *bufio.Writer
+}
+
+func (w respWriter) simple(s string) {
+	fmt.Fprintf(w.w, "+%s\r\n", s)
+}
+
+func (w respWriter) error(msg string) {
+	fmt.Fprintf(w.w, "-%s\r\n", msg)
+}
+
+func (w respWriter) integer(n int64) {
+	fmt.Fprintf(w.w, ":%d\r\n", n)
+}
+
+// bulk writes a bulk string, or the null bulk string if b is nil.
+func (w respWriter) bulk(b []byte) {
+	if b == nil {
+		w.w.WriteString("$-1\r\n")
+		return
+	}
+	fmt.Fprintf(w.w, "$%d\r\n", len(b))
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+func (w respWriter) array(items []string) {
+	fmt.Fprintf(w.w, "*%d\r\n", len(items))
+	for _, item := range items {
+		w.bulk([]byte(item))
+	}
+}
+
+// matchGlob reports whether s matches a Redis glob pattern: * matches any sequence, ? any single byte, [abc] and
+// [a-z] a set of bytes, [^abc] its complement, and \ escapes the next byte.
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range len(s) + 1 {
+				if matchGlob(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				// An unterminated set matches a literal '['.
+				if s[0] != '[' {
+					return false
+				}
+				pattern, s = pattern[1:], s[1:]
+				continue
+			}
+			if !matchSet(pattern[1:end+1], s[0]) {
+				return false
+			}
+			pattern, s = pattern[end+2:], s[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchSet reports whether c is in the set of a [...] pattern, given without brackets.
+func matchSet(set string, c byte) bool {
+	negate := strings.HasPrefix(set, "^")
+	if negate {
+		set = set[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(set); i++ {
+		if i+2 < len(set) && set[i+1] == '-' {
+			if set[i] <= c && c <= set[i+2] {
+				matched = true
+			}
+			i += 2
+			continue
+		}
+		if set[i] == c {
+			matched = true
+		}
+	}
+	return matched != negate
+}
+
+// globPrefix returns the literal prefix of a glob pattern, which every matching key starts with.
+func globPrefix(pattern string) string {
+	i := strings.IndexAny(pattern, `*?[\`)
+	if i < 0 {
+		return pattern
+	}
+	return pattern[:i]
+}
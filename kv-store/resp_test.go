@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$4\r\na\r\nb\r\nGET key\r\n"))
+
+	args, err := readCommand(r)
+	if err != nil {
+		t.Fatalf("error reading command: %v", err)
+	}
+	if len(args) != 3 || string(args[0]) != "SET" || string(args[2]) != "a\r\nb" {
+		t.Fatalf("unexpected arguments %q", args)
+	}
+
+	args, err = readCommand(r)
+	if err != nil {
+		t.Fatalf("error reading inline command: %v", err)
+	}
+	if len(args) != 2 || string(args[0]) != "GET" || string(args[1]) != "key" {
+		t.Fatalf("unexpected inline arguments %q", args)
+	}
+}
+
+func TestReadCommandTooManyArguments(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("*2147483647\r\n"))
+
+	_, err := readCommand(r)
+	if !errors.Is(err, errProtocol) {
+		t.Fatalf("expected a protocol error, got %v", err)
+	}
+}
+
+func TestReadCommandAllocatesAsDataArrives(t *testing.T) {
+	// A 512 MiB string and a million arguments are announced, but only a few bytes follow.
+	for _, input := range []string{"*1\r\n$536870912\r\nabc", "*1048576\r\n$1\r\na\r\n"} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+
+		_, err := readCommand(bufio.NewReader(strings.NewReader(input)))
+		if err == nil {
+			t.Fatalf("expected an error for a truncated command %q", input)
+		}
+
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 4<<20 {
+			t.Fatalf("expected a truncated command to allocate little, got %d bytes for %q", allocated, input)
+		}
+	}
+}
+
+func TestRESPServerOnMemoryStore(t *testing.T) {
+	s := NewRESPServer(NewMemoryStore())
+
+	tests := []struct {
+		command string
+		reply   string
+	}{
+		{"SET key value EX 60", "+OK\r\n"},
+		{"GET key", "$5\r\nvalue\r\n"},
+		{"TTL key", ":60\r\n"},
+		{"SET key value NX", "-ERR NX and XX are not supported by this store\r\n"},
+		{"INCR counter", "-ERR INCR is not supported by this store\r\n"},
+		{"DEL key missing", ":1\r\n"},
+		{"GET key", "$-1\r\n"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := respWriter{w: bufio.NewWriter(&buf)}
+		var args [][]byte
+		for field := range strings.FieldsSeq(tt.command) {
+			args = append(args, []byte(field))
+		}
+
+		s.runCommand(w, args)
+		w.w.Flush()
+		if buf.String() != tt.reply {
+			t.Errorf("%s: expected %q, got %q", tt.command, tt.reply, buf.String())
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"*", "anything", true},
+		{"session:*", "session:42", true},
+		{"session:*", "rate:42", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"*:*:end", "a:b:c:end", true},
+	}
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %t, want %t", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+
+	if prefix := globPrefix("session:*:x"); prefix != "session:" {
+		t.Errorf("expected prefix session:, got %q", prefix)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Scan returns up to limit live keys starting with prefix, in order, that come after cursor. Pass an empty
+// cursor to start from the beginning and the returned next cursor to continue. next is empty once every key has
+// been returned.
+func (k *KVStore) Scan(prefix, cursor string, limit int) (keys []string, next string, err error) {
+	return k.ScanContext(context.Background(), prefix, cursor, limit)
+}
+
+func (k *KVStore) ScanContext(ctx context.Context, prefix, cursor string, limit int) (keys []string, next string, err error) {
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("invalid limit %d: must be positive", limit)
+	}
+
+	// Fetch one extra key to find out whether there is another page.
+	rows, err := k.db.QueryContext(ctx, `
+	SELECT key FROM kv
+	WHERE STARTS_WITH(key, $1) AND key > $2 AND (expires_at IS NULL OR expires_at > NOW())
+	ORDER BY key LIMIT $3
+	`, prefix, cursor, limit+1)
+	if err != nil {
+		return nil, "", fmt.Errorf("error querying keys: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		err = rows.Scan(&key)
+		if err != nil {
+			return nil, "", fmt.Errorf("error scanning key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("error querying keys: %w", err)
+	}
+
+	if len(keys) > limit {
+		keys = keys[:limit]
+		next = keys[limit-1]
+	}
+	return keys, next, nil
+}
+
+// CountKeys returns the number of live keys starting with prefix.
+func (k *KVStore) CountKeys(prefix string) (int, error) {
+	return k.CountKeysContext(context.Background(), prefix)
+}
+
+func (k *KVStore) CountKeysContext(ctx context.Context, prefix string) (int, error) {
+	var count int
+
+	err := k.db.QueryRowContext(ctx, `
+	SELECT COUNT(*) FROM kv WHERE STARTS_WITH(key, $1) AND (expires_at IS NULL OR expires_at > NOW())
+	`, prefix).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error counting keys: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RESPServer serves a Store to Redis clients. It supports PING, GET, SET with EX and PX, DEL and TTL on any store,
+// and SET with NX and XX, EXPIRE, INCR and KEYS on stores that support them, such as KVStore.
+type RESPServer struct {
+	store Store
+	// extended is the store if it supports the optional commands, nil otherwise.
+	extended extendedStore
+	commands map[string]func(ctx context.Context, w respWriter, args [][]byte) error
+}
+
+var errUnsupported = errors.New("not supported by this store")
+
+func NewRESPServer(store Store) *RESPServer {
+	s := &RESPServer{store: store}
+	s.extended, _ = store.(extendedStore)
+	s.commands = map[string]func(ctx context.Context, w respWriter, args [][]byte) error{
+		"PING":   s.ping,
+		"GET":    s.get,
+		"SET":    s.set,
+		"DEL":    s.del,
+		"EXPIRE": s.expire,
+		"TTL":    s.ttl,
+		"INCR":   s.incr,
+		"KEYS":   s.keys,
+	}
+	return s
+}
+
+// ListenAndServe accepts connections on addr and serves each one in its own goroutine.
+func (s *RESPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening: %w", err)
+	}
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return fmt.Errorf("error accepting connection: %w", err)
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn runs the commands of a connection in order until the client disconnects.
+func (s *RESPServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	// A bug in a command handler drops the connection rather than the whole server.
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic serving %s: %v", conn.RemoteAddr(), r)
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	w := respWriter{w: bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				w.error("ERR " + err.Error())
+				w.w.Flush()
+			} else if err != io.EOF {
+				log.Printf("error reading from %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s.runCommand(w, args)
+
+		// Replies to pipelined commands are written together once the client waits for them.
+		if r.Buffered() == 0 {
+			err = w.w.Flush()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *RESPServer) runCommand(w respWriter, args [][]byte) {
+	name := strings.ToUpper(string(args[0]))
+	command, ok := s.commands[name]
+	if !ok {
+		w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+
+	err := command(context.Background(), w, args[1:])
+	if err != nil {
+		w.error("ERR " + err.Error())
+	}
+}
+
+var errSyntax = errors.New("syntax error")
+
+func wrongArgs(name string) error {
+	return fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(name))
+}
+
+func (s *RESPServer) ping(ctx context.Context, w respWriter, args [][]byte) error {
+	switch len(args) {
+	case 0:
+		w.simple("PONG")
+	case 1:
+		w.bulk(args[0])
+	default:
+		return wrongArgs("ping")
+	}
+	return nil
+}
+
+func (s *RESPServer) get(ctx context.Context, w respWriter, args [][]byte) error {
+	if len(args) != 1 {
+		return wrongArgs("get")
+	}
+
+	value, err := getBytes(ctx, s.store, string(args[0]))
+	if errors.Is(err, ErrNotFound) {
+		w.bulk(nil)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.bulk(value)
+	return nil
+}
+
+// set handles SET key value [NX | XX] [EX seconds | PX milliseconds].
+func (s *RESPServer) set(ctx context.Context, w respWriter, args [][]byte) error {
+	if len(args) < 2 {
+		return wrongArgs("set")
+	}
+	key, value := string(args[0]), args[1]
+
+	expiration := NoExpiration
+	var nx, xx, hasExpiration bool
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(string(args[i])); option {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if hasExpiration || i+1 == len(args) {
+				return errSyntax
+			}
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return errors.New("value is not an integer or out of range")
+			}
+			unit := time.Second
+			if option == "PX" {
+				unit = time.Millisecond
+			}
+			// Larger expire times would overflow the duration and wrap around.
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				return fmt.Errorf("invalid expire time in '%s' command", "set")
+			}
+			expiration, hasExpiration = time.Duration(n)*unit, true
+		default:
+			return errSyntax
+		}
+	}
+	if nx && xx {
+		return errSyntax
+	}
+
+	if (nx || xx) && s.extended == nil {
+		return fmt.Errorf("NX and XX are %w", errUnsupported)
+	}
+
+	stored := true
+	var err error
+	switch {
+	case nx:
+		stored, err = s.extended.PutIfAbsentContext(ctx, key, string(value), expiration)
+	case xx:
+		stored, err = s.extended.PutIfPresentContext(ctx, key, string(value), expiration)
+	default:
+		err = putBytes(ctx, s.store, key, value, expiration)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !stored {
+		w.bulk(nil)
+		return nil
+	}
+	w.simple("OK")
+	return nil
+}
+
+func (s *RESPServer) del(ctx context.Context, w respWriter, args [][]byte) error {
+	if len(args) == 0 {
+		return wrongArgs("del")
+	}
+
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	n, err := s.delKeys(ctx, keys)
+	if err != nil {
+		return err
+	}
+	w.integer(int64(n))
+	return nil
+}
+
+// delKeys deletes keys and returns how many existed. Without MDel, whether a key existed is checked before
+// deleting it, so a key written concurrently may be miscounted.
+func (s *RESPServer) delKeys(ctx context.Context, keys []string) (int, error) {
+	if s.extended != nil {
+		return s.extended.MDelContext(ctx, keys)
+	}
+
+	n := 0
+	for _, key := range keys {
+		_, err := s.store.TTLContext(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		err = s.store.DelContext(ctx, key)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (s *RESPServer) expire(ctx context.Context, w respWriter, args [][]byte) error {
+	if len(args) != 2 {
+		return wrongArgs("expire")
+	}
+	seconds, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return errors.New("value is not an integer or out of range")
+	}
+	if seconds > math.MaxInt64/int64(time.Second) {
+		return fmt.Errorf("invalid expire time in '%s' command", "expire")
+	}
+	if s.extended == nil {
+		return fmt.Errorf("EXPIRE is %w", errUnsupported)
+	}
+
+	// Like Redis, a non-positive TTL expires the key right away.
+	ok, err := s.extended.ExpireContext(ctx, string(args[0]), time.Duration(max(seconds, 0))*time.Second)
+	if err != nil {
+		return err
+	}
+	if ok {
+		w.integer(1)
+	} else {
+		w.integer(0)
+	}
+	return nil
+}
+
+// ttl replies with the seconds left until the key expires, -1 if it never does and -2 if it doesn't exist.
+func (s *RESPServer) ttl(ctx context.Context, w respWriter, args [][]byte) error {
+	if len(args) != 1 {
+		return wrongArgs("ttl")
+	}
+
+	ttl, err := s.store.TTLContext(ctx, string(args[0]))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		w.integer(-2)
+	case err != nil:
+		return err
+	case ttl == NoExpiration:
+		w.integer(-1)
+	default:
+		w.integer(int64(ttl.Round(time.Second) / time.Second))
+	}
+	return nil
+}
+
+// incr increments the integer stored under key, starting from 0 if it doesn't exist.
+func (s *RESPServer) incr(ctx context.Context, w respWriter, args [][]byte) error {
+	if len(args) != 1 {
+		return wrongArgs("incr")
+	}
+	key := string(args[0])
+	if s.extended == nil {
+		return fmt.Errorf("INCR is %w", errUnsupported)
+	}
+
+	value, err := s.extended.IncrementContext(ctx, key, 1)
+	if errors.Is(err, ErrNotFound) {
+		// Create the counter. If another client creates it first, PutIfAbsent does nothing and the increment
+		// below applies to theirs.
+		_, err = s.extended.PutIfAbsentContext(ctx, key, "0", NoExpiration)
+		if err != nil {
+			return err
+		}
+		value, err = s.extended.IncrementContext(ctx, key, 1)
+	}
+	if errors.Is(err, ErrNotInteger) {
+		return ErrNotInteger
+	}
+	if err != nil {
+		return err
+	}
+	w.integer(value)
+	return nil
+}
+
+// keys replies with every live key matching a glob pattern. Only keys starting with the literal prefix of the
+// pattern are read from the database.
+func (s *RESPServer) keys(ctx context.Context, w respWriter, args [][]byte) error {
+	if len(args) != 1 {
+		return wrongArgs("keys")
+	}
+	pattern := string(args[0])
+	if s.extended == nil {
+		return fmt.Errorf("KEYS is %w", errUnsupported)
+	}
+
+	matched := []string{}
+	cursor := ""
+	for {
+		keys, next, err := s.extended.ScanContext(ctx, globPrefix(pattern), cursor, 1000)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if matchGlob(pattern, key) {
+				matched = append(matched, key)
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	w.array(matched)
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Store is a key-value store with expiring keys. KVStore implements it on Postgres, MemoryStore in memory and
+// FileStore on an append-only log, so code written against it runs with or without a database.
+type Store interface {
+	// PutContext stores the value under key for the given duration, or forever if expiration is NoExpiration.
+	PutContext(ctx context.Context, key string, value string, expiration time.Duration) error
+	// GetContext returns the value stored under key. It returns an error matching ErrNotFound if the key doesn't
+	// exist or has expired.
+	GetContext(ctx context.Context, key string) (string, error)
+	// DelContext removes key. Deleting a key that doesn't exist is not an error.
+	DelContext(ctx context.Context, key string) error
+	// TTLContext returns the time left until key expires, or NoExpiration if it never does. It returns an error
+	// matching ErrNotFound if the key doesn't exist or has expired.
+	TTLContext(ctx context.Context, key string) (time.Duration, error)
+}
+
+// extendedStore is a Store with conditional writes, counters and key scans. KVStore implements it.
+type extendedStore interface {
+	Store
+	PutIfAbsentContext(ctx context.Context, key string, value string, expiration time.Duration) (bool, error)
+	PutIfPresentContext(ctx context.Context, key string, value string, expiration time.Duration) (bool, error)
+	ExpireContext(ctx context.Context, key string, expiration time.Duration) (bool, error)
+	IncrementContext(ctx context.Context, key string, delta int64) (int64, error)
+	MDelContext(ctx context.Context, keys []string) (int, error)
+	ScanContext(ctx context.Context, prefix, cursor string, limit int) (keys []string, next string, err error)
+}
+
+// bytesStore is a Store that reads and writes values as bytes, e.g. to check their size or cache them. KVStore
+// implements it.
+type bytesStore interface {
+	Store
+	PutBytesContext(ctx context.Context, key string, value []byte, expiration time.Duration) error
+	GetBytesContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// putBytes stores a binary value in any Store.
+func putBytes(ctx context.Context, store Store, key string, value []byte, expiration time.Duration) error {
+	if bs, ok := store.(bytesStore); ok {
+		return bs.PutBytesContext(ctx, key, value, expiration)
+	}
+	return store.PutContext(ctx, key, string(value), expiration)
+}
+
+// getBytes returns a binary value from any Store.
+func getBytes(ctx context.Context, store Store, key string) ([]byte, error) {
+	if bs, ok := store.(bytesStore); ok {
+		return bs.GetBytesContext(ctx, key)
+	}
+	value, err := store.GetContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+var (
+	_ extendedStore = (*KVStore)(nil)
+	_ bytesStore    = (*KVStore)(nil)
+
+	_ Store = (*KVStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*FileStore)(nil)
+)
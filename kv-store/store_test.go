@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// testStore checks that a Store implementation behaves like every other one.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	ctx := context.Background()
+
+	t.Run("PutGet", func(t *testing.T) {
+		s := newStore(t)
+		err := s.PutContext(ctx, "key", "value", time.Minute)
+		if err != nil {
+			t.Fatalf("error putting key: %v", err)
+		}
+		value, err := s.GetContext(ctx, "key")
+		if err != nil || value != "value" {
+			t.Fatalf("expected value, got %q, %v", value, err)
+		}
+
+		err = s.PutContext(ctx, "key", "new value", time.Minute)
+		if err != nil {
+			t.Fatalf("error overwriting key: %v", err)
+		}
+		value, _ = s.GetContext(ctx, "key")
+		if value != "new value" {
+			t.Fatalf("expected new value, got %q", value)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		s := newStore(t)
+		_, err := s.GetContext(ctx, "missing")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+		_, err = s.TTLContext(ctx, "missing")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound from TTL, got %v", err)
+		}
+	})
+
+	t.Run("Del", func(t *testing.T) {
+		s := newStore(t)
+		s.PutContext(ctx, "key", "value", time.Minute)
+		err := s.DelContext(ctx, "key")
+		if err != nil {
+			t.Fatalf("error deleting key: %v", err)
+		}
+		_, err = s.GetContext(ctx, "key")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound after delete, got %v", err)
+		}
+		err = s.DelContext(ctx, "key")
+		if err != nil {
+			t.Fatalf("expected deleting a missing key to succeed, got %v", err)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		s := newStore(t)
+		s.PutContext(ctx, "key", "value", 50*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+
+		_, err := s.GetContext(ctx, "key")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected expired key to be not found, got %v", err)
+		}
+		_, err = s.TTLContext(ctx, "key")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected TTL of expired key to be not found, got %v", err)
+		}
+
+		// A deadline that has just passed expires the key instead of keeping it forever.
+		s.PutContext(ctx, "late", "value", -time.Millisecond)
+		_, err = s.GetContext(ctx, "late")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected key with a negative expiration to be not found, got %v", err)
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		s := newStore(t)
+		s.PutContext(ctx, "expiring", "value", time.Minute)
+		s.PutContext(ctx, "persistent", "value", NoExpiration)
+
+		ttl, err := s.TTLContext(ctx, "expiring")
+		if err != nil || ttl <= 0 || ttl > time.Minute {
+			t.Fatalf("expected TTL in (0, 1m], got %v, %v", ttl, err)
+		}
+		ttl, err = s.TTLContext(ctx, "persistent")
+		if err != nil || ttl != NoExpiration {
+			t.Fatalf("expected NoExpiration, got %v, %v", ttl, err)
+		}
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		return NewMemoryStore()
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		s, err := OpenFileStore(filepath.Join(t.TempDir(), "kv.log"))
+		if err != nil {
+			t.Fatalf("error opening store: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+// TestPostgresStore runs the conformance tests against the database in KV_TEST_DSN. It drops the `kv` table.
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("KV_TEST_DSN")
+	if dsn == "" {
+		t.Skip("KV_TEST_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("error opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	testStore(t, func(t *testing.T) Store {
+		err := setupDatabase(db)
+		if err != nil {
+			t.Fatalf("error setting up database: %v", err)
+		}
+		return NewKVStore(db)
+	})
+}
+
+func TestFileStoreRecovery(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "kv.log")
+
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	s.PutContext(ctx, "kept", "value", NoExpiration)
+	s.PutContext(ctx, "deleted", "value", NoExpiration)
+	s.DelContext(ctx, "deleted")
+	s.Close()
+
+	// Simulate a crash in the middle of appending a record.
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"op":"put","key":"torn"`)
+	f.Close()
+
+	s, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("error reopening store: %v", err)
+	}
+	defer s.Close()
+
+	value, err := s.GetContext(ctx, "kept")
+	if err != nil || value != "value" {
+		t.Fatalf("expected kept key to survive, got %q, %v", value, err)
+	}
+	for _, key := range []string{"deleted", "torn"} {
+		_, err = s.GetContext(ctx, key)
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected %s to be not found, got %v", key, err)
+		}
+	}
+
+	err = s.PutContext(ctx, "after", "value", NoExpiration)
+	if err != nil {
+		t.Fatalf("error writing after recovery: %v", err)
+	}
+}
+
+func TestFileStoreFailedAppend(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "kv.log")
+
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	s.PutContext(ctx, "before", "value", NoExpiration)
+
+	// Make the next append fail by swapping in a handle that can't be written to.
+	file := s.file
+	s.file, _ = os.Open(path)
+	err = s.PutContext(ctx, "failed", "value", NoExpiration)
+	if err == nil {
+		t.Fatal("expected append to a read-only log to fail")
+	}
+	s.file.Close()
+	s.file = file
+
+	_, err = s.GetContext(ctx, "failed")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected failed write not to be applied, got %v", err)
+	}
+	err = s.PutContext(ctx, "after", "value", NoExpiration)
+	if err != nil {
+		t.Fatalf("error writing after failed append: %v", err)
+	}
+	s.Close()
+
+	s, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("error reopening store: %v", err)
+	}
+	defer s.Close()
+
+	for _, key := range []string{"before", "after"} {
+		value, err := s.GetContext(ctx, key)
+		if err != nil || value != "value" {
+			t.Fatalf("expected %s to survive, got %q, %v", key, value, err)
+		}
+	}
+	_, err = s.GetContext(ctx, "failed")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected failed write to be not found, got %v", err)
+	}
+}
+
+func TestFileStoreCompactionSyncFailure(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "kv.log")
+
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	s.PutContext(ctx, "before", "value", NoExpiration)
+
+	original := syncDir
+	syncDir = func(dir string) error {
+		return errors.New("sync failed")
+	}
+	err = s.Compact()
+	syncDir = original
+	if err == nil {
+		t.Fatal("expected compaction to report the failed directory sync")
+	}
+
+	err = s.PutContext(ctx, "after", "value", NoExpiration)
+	if err != nil {
+		t.Fatalf("error writing after failed compaction: %v", err)
+	}
+	s.Close()
+
+	s, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("error reopening store: %v", err)
+	}
+	defer s.Close()
+
+	for _, key := range []string{"before", "after"} {
+		value, err := s.GetContext(ctx, key)
+		if err != nil || value != "value" {
+			t.Fatalf("expected %s to survive, got %q, %v", key, value, err)
+		}
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "kv.log")
+
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("error opening store: %v", err)
+	}
+	for i := range 2 * compactMinRecords {
+		s.PutContext(ctx, "key", string(rune('a'+i%26)), NoExpiration)
+	}
+	s.PutContext(ctx, "expired", "value", time.Nanosecond)
+	err = s.Compact()
+	if err != nil {
+		t.Fatalf("error compacting: %v", err)
+	}
+	if s.records != 1 {
+		t.Fatalf("expected 1 record after compaction, got %d", s.records)
+	}
+	want, _ := s.GetContext(ctx, "key")
+	s.Close()
+
+	s, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("error reopening store: %v", err)
+	}
+	defer s.Close()
+
+	got, err := s.GetContext(ctx, "key")
+	if err != nil || got != want {
+		t.Fatalf("expected %q after compaction, got %q, %v", want, got, err)
+	}
+}
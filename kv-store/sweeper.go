@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// SweeperConfig controls how the background sweeper removes expired keys.
+type SweeperConfig struct {
+	// Interval is the time between two sweeps.
+	Interval time.Duration
+	// BatchSize is the maximum number of rows deleted by a single statement, so that a sweep never holds locks
+	// on a large part of the table.
+	BatchSize int
+}
+
+// StartSweeper starts a goroutine that periodically deletes expired keys from the `kv` table. It runs
+// until ctx is cancelled or the store is closed. Only one sweeper runs per store.
+func (k *KVStore) StartSweeper(ctx context.Context, cfg SweeperConfig) error {
+	if cfg.Interval <= 0 || cfg.BatchSize <= 0 {
+		return fmt.Errorf("invalid sweeper config: interval and batch size must be positive")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.stopSweeper != nil {
+		return errors.New("sweeper is already running")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	k.stopSweeper = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			_, err := k.SweepExpired(ctx, cfg.BatchSize)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("error sweeping expired keys: %v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// SweepExpired deletes every key that expired before now, batchSize rows at a time, and returns the number of
+// rows deleted.
+func (k *KVStore) SweepExpired(ctx context.Context, batchSize int) (int, error) {
+	total := 0
+	for {
+		res, err := k.db.ExecContext(ctx, `
+		DELETE FROM kv WHERE key IN (
+			SELECT key FROM kv WHERE expires_at <= NOW() LIMIT $1 FOR UPDATE SKIP LOCKED
+		)
+		`, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("error deleting expired keys: %v", err)
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("error getting rows affected: %v", err)
+		}
+		total += int(n)
+		k.swept.Add(n)
+
+		if int(n) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// Swept returns the number of rows the store's sweeps have removed so far.
+func (k *KVStore) Swept() int64 {
+	return k.swept.Load()
+}
+
+// Close stops the sweeper and the cache invalidation listener, if they are running, and waits for them to exit.
+// It does not close the database.
+func (k *KVStore) Close() error {
+	k.mu.Lock()
+	stops := []func(){k.stopSweeper, k.stopCache}
+	k.stopSweeper, k.stopCache = nil, nil
+	k.mu.Unlock()
+
+	for _, stop := range stops {
+		if stop != nil {
+			stop()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// NoExpiration is the expiration of keys that never expire. Other negative durations, e.g. time.Until of a
+// deadline that has passed, expire the key right away like zero does.
+const NoExpiration time.Duration = -1
+
+// expiryTime returns the `expires_at` value of a key stored now with the given expiration, NULL if it never
+// expires.
+func expiryTime(expiration time.Duration) sql.NullTime {
+	if expiration == NoExpiration {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: time.Now().Add(max(expiration, 0)), Valid: true}
+}
+
+// TTL returns the time left until key expires, or NoExpiration if it never does. It returns ErrNotFound if the
+// key doesn't exist and ErrExpired if it has expired.
+func (k *KVStore) TTL(key string) (time.Duration, error) {
+	return k.TTLContext(context.Background(), key)
+}
+
+func (k *KVStore) TTLContext(ctx context.Context, key string) (time.Duration, error) {
+	var seconds sql.NullFloat64
+
+	err := k.db.QueryRowContext(ctx, `
+	SELECT EXTRACT(EPOCH FROM expires_at - NOW()) FROM kv WHERE key = $1
+	`, key).Scan(&seconds)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("error getting TTL of %q: %w", key, ErrNotFound)
+		}
+		return 0, fmt.Errorf("error scanning TTL: %w", err)
+	}
+
+	if !seconds.Valid {
+		return NoExpiration, nil
+	}
+	if seconds.Float64 <= 0 {
+		return 0, fmt.Errorf("error getting TTL of %q: %w", key, ErrExpired)
+	}
+	return time.Duration(seconds.Float64 * float64(time.Second)), nil
+}
+
+// Expire sets the time left until key expires, or makes it persistent if expiration is NoExpiration. It reports
+// whether the key exists.
+func (k *KVStore) Expire(key string, expiration time.Duration) (bool, error) {
+	return k.ExpireContext(context.Background(), key, expiration)
+}
+
+func (k *KVStore) ExpireContext(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	res, err := k.db.ExecContext(ctx, `
+	UPDATE kv SET expires_at = $2 WHERE key = $1 AND (expires_at IS NULL OR expires_at > NOW())
+	`, key, expiryTime(expiration))
+	k.invalidate(key)
+	if err != nil {
+		return false, fmt.Errorf("error updating expiration: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return n == 1, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Codec turns Go values into bytes and back.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes values with encoding/json.
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobCodec encodes values with encoding/gob. Every value is encoded on its own, so each one carries its type
+// description.
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// TypedStore stores values of type T in a Store, encoded with a Codec.
+type TypedStore[T any] struct {
+	store Store
+	codec Codec
+}
+
+func NewTypedStore[T any](store Store, codec Codec) *TypedStore[T] {
+	return &TypedStore[T]{store: store, codec: codec}
+}
+
+// Put encodes value and stores it under key for the given duration, or forever if expiration is NoExpiration.
+func (t *TypedStore[T]) Put(key string, value T, expiration time.Duration) error {
+	return t.PutContext(context.Background(), key, value, expiration)
+}
+
+func (t *TypedStore[T]) PutContext(ctx context.Context, key string, value T, expiration time.Duration) error {
+	data, err := t.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error encoding value of %q: %w", key, err)
+	}
+	return putBytes(ctx, t.store, key, data, expiration)
+}
+
+// Get returns the decoded value stored under key. It returns the same errors as the store's GetContext.
+func (t *TypedStore[T]) Get(key string) (T, error) {
+	return t.GetContext(context.Background(), key)
+}
+
+func (t *TypedStore[T]) GetContext(ctx context.Context, key string) (T, error) {
+	var value T
+
+	data, err := getBytes(ctx, t.store, key)
+	if err != nil {
+		return value, err
+	}
+
+	err = t.codec.Unmarshal(data, &value)
+	if err != nil {
+		return value, fmt.Errorf("error decoding value of %q: %w", key, err)
+	}
+	return value, nil
+}
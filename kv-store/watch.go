@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// changesChannel is the channel the `kv` trigger notifies of every row written or deleted.
+const changesChannel = "kv_changes"
+
+// EventType is the kind of change a WatchEvent reports.
+type EventType string
+
+const (
+	EventPut    EventType = "put"
+	EventDelete EventType = "delete"
+	// EventExpire is reported when an expired key is removed, usually by the sweeper, not when it expires.
+	EventExpire EventType = "expire"
+)
+
+// WatchEvent is a change to a key.
+type WatchEvent struct {
+	Type EventType
+	Key  string
+	// Value is the new value of a put, nil for deletes and expiries.
+	Value []byte
+}
+
+// change is the payload of a notification sent by the `kv` trigger.
+type change struct {
+	Op    EventType `json:"op"`
+	Key   string    `json:"key"`
+	Value []byte    `json:"value"`
+	// Large is set if the value didn't fit into the notification and has to be read from the table.
+	Large bool `json:"large"`
+}
+
+func parseChange(payload string) (change, error) {
+	var c change
+	err := json.Unmarshal([]byte(payload), &c)
+	if err != nil {
+		return change{}, fmt.Errorf("error decoding change %q: %w", payload, err)
+	}
+	return c, nil
+}
+
+// listenForChanges opens a listener connection subscribed to the changes of the `kv` table. name identifies the
+// listener in logs.
+func (k *KVStore) listenForChanges(name string) (*pq.Listener, error) {
+	if k.dsn == "" {
+		return nil, errors.New("the store has no DSN to listen for changes with")
+	}
+
+	listener := pq.NewListener(k.dsn, 100*time.Millisecond, 10*time.Second, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("%s listener: %v", name, err)
+		}
+	})
+	err := listener.Listen(changesChannel)
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error listening for changes: %w", err)
+	}
+	return listener, nil
+}
+
+// Watch returns a channel of the changes to a key, or to every key starting with a prefix if keyOrPrefix ends with
+// "*". Changes made while the listener connection is being re-established are lost. The channel is closed when
+// ctx is cancelled.
+func (k *KVStore) Watch(ctx context.Context, keyOrPrefix string) (<-chan WatchEvent, error) {
+	listener, err := k.listenForChanges("watch " + keyOrPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix, isPrefix := strings.CutSuffix(keyOrPrefix, "*")
+	matches := func(key string) bool {
+		if isPrefix {
+			return strings.HasPrefix(key, prefix)
+		}
+		return key == keyOrPrefix
+	}
+
+	events := make(chan WatchEvent, 64)
+	go func() {
+		defer close(events)
+		defer listener.Close()
+
+		for {
+			var n *pq.Notification
+			select {
+			case <-ctx.Done():
+				return
+			case n = <-listener.Notify:
+			case <-time.After(time.Minute):
+				go listener.Ping()
+				continue
+			}
+			if n == nil {
+				log.Printf("watch %s: reconnected, changes may have been lost", keyOrPrefix)
+				continue
+			}
+
+			c, err := parseChange(n.Extra)
+			if err != nil {
+				log.Printf("error parsing change notification: %v", err)
+				continue
+			}
+			if !matches(c.Key) {
+				continue
+			}
+
+			event := WatchEvent{Type: c.Op, Key: c.Key, Value: c.Value}
+			if c.Large {
+				event.Value, err = k.GetBytesContext(ctx, c.Key)
+				if err != nil {
+					// The key was deleted since, and the delete has its own notification.
+					continue
+				}
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}